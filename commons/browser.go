@@ -0,0 +1,379 @@
+package commons
+
+import (
+	"context"
+	"fmt"
+	"github.com/playwright-community/playwright-go"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Engine chooses how pages are loaded
+type Engine string
+
+const (
+	EngineAuto    Engine = "auto"    // plain HTTP, the browser for pages that need JavaScript
+	EngineHTTP    Engine = "http"    // never start a browser
+	EngineBrowser Engine = "browser" // load every page in the browser
+)
+
+func parseEngine(value string) (Engine, error) {
+	switch Engine(value) {
+	case EngineAuto, EngineHTTP, EngineBrowser:
+		return Engine(value), nil
+	default:
+		return "", fmt.Errorf("unknown engine %q, expected 'auto', 'http' or 'browser'", value)
+	}
+}
+
+// BrowserType is the browser engine Playwright drives
+type BrowserType string
+
+const (
+	BrowserFirefox  BrowserType = "firefox"
+	BrowserChromium BrowserType = "chromium"
+	BrowserWebKit   BrowserType = "webkit"
+)
+
+func parseBrowserType(value string) (BrowserType, error) {
+	switch BrowserType(value) {
+	case BrowserFirefox, BrowserChromium, BrowserWebKit:
+		return BrowserType(value), nil
+	default:
+		return "", fmt.Errorf("unknown browser %q, expected 'firefox', 'chromium' or 'webkit'", value)
+	}
+}
+
+// BrowserOptions controls how the browser is installed and launched
+type BrowserOptions struct {
+	Type        BrowserType
+	Headless    bool
+	Executable  string           // installed browser to launch instead of the one Playwright downloads
+	Args        []string         // extra command line arguments for the browser
+	Viewport    *playwright.Size // nil for the Playwright default
+	Locale      string           // e.g. it-IT, empty for the system one
+	Timezone    string           // e.g. Europe/Rome, empty for the system one
+	SkipInstall bool             // the driver and browser are already installed
+	Profile     string           // user data directory kept between runs, empty for a fresh one
+	Endpoint    string           // running browser to connect to instead of launching one
+	Trace       bool             // record a Playwright trace, saved with SaveTrace
+	Cookies     []*http.Cookie   // added to the browser on launch
+	Proxy       *playwright.Proxy
+	Jar         http.CookieJar // receives the cookies of the profile, so plain HTTP shares the session
+}
+
+// Browser starts Playwright and the browser the first time a page is
+// needed, so runs that get by with plain HTTP never install or launch
+// them. Up to a fixed number of pages are open at once, sharing cookies.
+type Browser struct {
+	options BrowserOptions
+
+	mu         sync.Mutex
+	playwright *playwright.Playwright
+	browser    playwright.Browser
+	context    playwright.BrowserContext
+	err        error // launch failure, not retried
+
+	idle  chan playwright.Page // pages free to use
+	slots chan struct{}        // one token per page that may still be opened
+}
+
+func newBrowser(options BrowserOptions, pages int) *Browser {
+	b := &Browser{
+		options: options,
+		idle:    make(chan playwright.Page, pages),
+		slots:   make(chan struct{}, pages),
+	}
+	for range pages {
+		b.slots <- struct{}{}
+	}
+	return b
+}
+
+// AcquirePage returns a page for the caller's exclusive use, launching the
+// browser if needed and waiting while every page is busy. Pages go back to
+// the pool with ReleasePage.
+func (b *Browser) AcquirePage(ctx context.Context) (playwright.Page, error) {
+	select {
+	case page := <-b.idle:
+		return page, nil
+	default:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case page := <-b.idle:
+		return page, nil
+	case <-b.slots:
+	}
+
+	page, err := b.newPage()
+	if err != nil {
+		b.slots <- struct{}{}
+		return nil, err
+	}
+	return page, nil
+}
+
+// ReleasePage returns a page taken with AcquirePage to the pool
+func (b *Browser) ReleasePage(page playwright.Page) {
+	b.idle <- page
+}
+
+func (b *Browser) newPage() (playwright.Page, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.context == nil && b.err == nil {
+		b.err = b.launch()
+		if b.err != nil {
+			b.close()
+		}
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	page, err := b.context.NewPage()
+	if err != nil {
+		return nil, fmt.Errorf("could not create page: %w", err)
+	}
+	return page, nil
+}
+
+func (b *Browser) launch() error {
+	if b.options.Endpoint != "" {
+		slog.Info("Connecting to browser", "browser", b.options.Type, "endpoint", b.options.Endpoint)
+	} else {
+		slog.Info("Starting browser", "browser", b.options.Type)
+	}
+	if err := installDeps(b.options); err != nil {
+		return err
+	}
+
+	pw, err := playwright.Run(&playwright.RunOptions{Browsers: []string{string(b.options.Type)}, Stdout: os.Stderr})
+	if err != nil {
+		return fmt.Errorf("could not start playwright: %w", err)
+	}
+	b.playwright = pw
+
+	if b.options.Profile != "" {
+		err = b.launchPersistent()
+	} else {
+		err = b.launchFresh()
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(b.options.Cookies) > 0 {
+		if err := b.context.AddCookies(browserCookies(b.options.Cookies)); err != nil {
+			return fmt.Errorf("could not add cookies to the browser: %w", err)
+		}
+	}
+	if b.options.Trace {
+		err := b.context.Tracing().Start(playwright.TracingStartOptions{
+			Screenshots: playwright.Bool(true),
+			Snapshots:   playwright.Bool(true),
+		})
+		if err != nil {
+			slog.Warn("could not start tracing", "error", err)
+		}
+	}
+	if b.options.Profile != "" && b.options.Jar != nil {
+		cookies, err := b.context.Cookies()
+		if err != nil {
+			return fmt.Errorf("could not read the cookies of the profile: %w", err)
+		}
+		setJarCookies(b.options.Jar, jarCookies(cookies))
+	}
+	return nil
+}
+
+func (b *Browser) launchFresh() error {
+	browser, err := openBrowser(b.playwright, b.options)
+	if err != nil {
+		return err
+	}
+	b.browser = browser
+
+	contextOptions := playwright.BrowserNewContextOptions{
+		Viewport:   b.options.Viewport,
+		Locale:     optionalString(b.options.Locale),
+		TimezoneId: optionalString(b.options.Timezone),
+	}
+	// A browser launched here got the proxy on launch
+	if b.options.Endpoint != "" {
+		contextOptions.Proxy = b.options.Proxy
+	}
+	browserContext, err := browser.NewContext(contextOptions)
+	if err != nil {
+		return fmt.Errorf("could not create browser context: %w", err)
+	}
+	b.context = browserContext
+	return nil
+}
+
+// launchPersistent opens the browser on the profile directory, which keeps
+// logins and consent choices between runs
+func (b *Browser) launchPersistent() error {
+	if err := os.MkdirAll(b.options.Profile, 0755); err != nil {
+		return fmt.Errorf("could not create browser profile: %w", err)
+	}
+	launch := launchOptions(b.options)
+	browserContext, err := browserType(b.playwright, b.options.Type).LaunchPersistentContext(b.options.Profile, playwright.BrowserTypeLaunchPersistentContextOptions{
+		Headless:       launch.Headless,
+		Proxy:          launch.Proxy,
+		ExecutablePath: launch.ExecutablePath,
+		Args:           launch.Args,
+		Viewport:       b.options.Viewport,
+		Locale:         optionalString(b.options.Locale),
+		TimezoneId:     optionalString(b.options.Timezone),
+	})
+	if err != nil {
+		return fmt.Errorf("could not launch browser with profile %s: %w", b.options.Profile, err)
+	}
+	b.context = browserContext
+	return nil
+}
+
+// openBrowser launches the browser, or connects to the one at the endpoint.
+// http endpoints are Chromium's DevTools protocol, ws ones a Playwright
+// server started with launchServer or run-server.
+func openBrowser(pw *playwright.Playwright, options BrowserOptions) (playwright.Browser, error) {
+	if options.Endpoint == "" {
+		browser, err := browserType(pw, options.Type).Launch(launchOptions(options))
+		if err != nil {
+			return nil, fmt.Errorf("could not launch browser: %w", err)
+		}
+		return browser, nil
+	}
+
+	var browser playwright.Browser
+	var err error
+	if isCDPEndpoint(options.Endpoint) {
+		browser, err = pw.Chromium.ConnectOverCDP(options.Endpoint)
+	} else {
+		browser, err = browserType(pw, options.Type).Connect(options.Endpoint)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to browser at %s: %w", options.Endpoint, err)
+	}
+	return browser, nil
+}
+
+func isCDPEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://")
+}
+
+// parseEndpoint checks that a browser endpoint can be connected to with
+// the chosen browser
+func parseEndpoint(value string, browser BrowserType) (string, error) {
+	endpoint, err := url.Parse(value)
+	if err != nil || endpoint.Host == "" {
+		return "", fmt.Errorf("invalid browser endpoint %q, expected a URL like ws://host:3000/ or http://host:9222", value)
+	}
+	switch endpoint.Scheme {
+	case "ws", "wss":
+	case "http", "https":
+		if browser != BrowserChromium {
+			return "", fmt.Errorf("%s is a DevTools endpoint, which needs --browser chromium", value)
+		}
+	default:
+		return "", fmt.Errorf("unsupported browser endpoint scheme %q, expected ws, wss, http or https", endpoint.Scheme)
+	}
+	return value, nil
+}
+
+func browserType(pw *playwright.Playwright, t BrowserType) playwright.BrowserType {
+	switch t {
+	case BrowserChromium:
+		return pw.Chromium
+	case BrowserWebKit:
+		return pw.WebKit
+	default:
+		return pw.Firefox
+	}
+}
+
+func launchOptions(options BrowserOptions) playwright.BrowserTypeLaunchOptions {
+	return playwright.BrowserTypeLaunchOptions{
+		Headless:       playwright.Bool(options.Headless),
+		Proxy:          options.Proxy,
+		ExecutablePath: optionalString(options.Executable),
+		Args:           options.Args,
+	}
+}
+
+// optionalString leaves empty options unset, so Playwright uses its default
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// parseViewport parses a size like 1280x720
+func parseViewport(value string) (*playwright.Size, error) {
+	width, height, ok := strings.Cut(strings.ToLower(value), "x")
+	w, errW := strconv.Atoi(width)
+	h, errH := strconv.Atoi(height)
+	if !ok || errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("invalid viewport %q, expected a size like 1280x720", value)
+	}
+	return &playwright.Size{Width: w, Height: h}, nil
+}
+
+// SaveTrace writes what the browser did since the previous call to a
+// Playwright trace file, viewable with "playwright show-trace"
+func (b *Browser) SaveTrace(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.context == nil || !b.options.Trace {
+		return fmt.Errorf("tracing is not running")
+	}
+	if err := b.context.Tracing().StopChunk(path); err != nil {
+		return fmt.Errorf("could not save trace: %w", err)
+	}
+	if err := b.context.Tracing().StartChunk(); err != nil {
+		return fmt.Errorf("could not restart tracing: %w", err)
+	}
+	return nil
+}
+
+// Close shuts the browser down if it was started
+func (b *Browser) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.close()
+}
+
+func (b *Browser) close() {
+	// A persistent context is the browser itself
+	if b.browser == nil && b.context != nil {
+		if err := b.context.Close(); err != nil {
+			slog.Error("could not close browser", "error", err)
+		}
+	}
+	if b.browser != nil {
+		if err := b.browser.Close(); err != nil {
+			slog.Error("could not close browser", "error", err)
+		}
+		b.browser = nil
+	}
+	if b.playwright != nil {
+		if err := b.playwright.Stop(); err != nil {
+			slog.Error("could not stop Playwright", "error", err)
+		}
+		b.playwright = nil
+	}
+	b.context = nil
+}
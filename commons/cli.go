@@ -0,0 +1,426 @@
+package commons
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type command struct {
+	Name    Action
+	Args    string // positional arguments shown in the usage line
+	Summary string
+	Browser bool // scrapes sites, through HTTP or a browser
+}
+
+var commands = []command{
+	{Name: Download, Summary: "Download episodes from a series page", Browser: true},
+	{Name: Search, Args: "[title]", Summary: "Find a series by title, or get streaming links without downloading", Browser: true},
+	{Name: List, Summary: "List the episodes of a series", Browser: true},
+	{Name: Sync, Summary: "Download new episodes of every series in the watchlist", Browser: true},
+	{Name: Serve, Summary: "Run an HTTP API that queues downloads", Browser: true},
+	{Name: Doctor, Summary: "Check that dependencies are installed and working"},
+	{Name: Completion, Args: "<bash|zsh|fish>", Summary: "Print a shell completion script"},
+	{Name: Help, Args: "[command]", Summary: "Show help for a command"},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if string(cmd.Name) == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// cliOptions holds the raw flag values before validation
+type cliOptions struct {
+	link         string
+	site         string
+	pick         int
+	lang         string
+	allSeasons   bool
+	episodes     string
+	episodeRange string
+	only         string
+	batch        int
+	speed        float64
+	headless     bool
+	browser      string
+	browserPath  string
+	browserArgs  string
+	viewport     string
+	locale       string
+	timezone     string
+	skipInstall  bool
+	profileDir   string
+	cookies      string
+	connect      string
+	debugDir     string
+	engine       string
+	resolvers    int
+	requestDelay time.Duration
+	output       string
+	resolve      bool
+	export       string
+	exportFile   string
+	watchlist    string
+	listen       string
+	quality      string
+	outputDir    string
+	template     string
+	layout       string
+	noTags       bool
+	proxy        string
+	profile      string
+	config       string
+	logLevel     string
+	logFormat    string
+	logFile      string
+}
+
+// flagSpec is the single definition of a flag, used for parsing, help and
+// shell completion
+type flagSpec struct {
+	Name     string
+	Short    string
+	Arg      string // value placeholder, empty for boolean flags
+	Usage    string
+	Values   []string // accepted values, offered by shell completion
+	File     bool     // the value is a path
+	Commands []Action // commands accepting the flag, nil for all of them
+	bind     func(fs *flag.FlagSet, name string)
+}
+
+func (f flagSpec) appliesTo(cmd Action) bool {
+	return f.Commands == nil || slices.Contains(f.Commands, cmd)
+}
+
+func stringFlag(p *string, value string) func(*flag.FlagSet, string) {
+	return func(fs *flag.FlagSet, name string) { fs.StringVar(p, name, value, "") }
+}
+
+func intFlag(p *int, value int) func(*flag.FlagSet, string) {
+	return func(fs *flag.FlagSet, name string) { fs.IntVar(p, name, value, "") }
+}
+
+func floatFlag(p *float64, value float64) func(*flag.FlagSet, string) {
+	return func(fs *flag.FlagSet, name string) { fs.Float64Var(p, name, value, "") }
+}
+
+func durationFlag(p *time.Duration, value time.Duration) func(*flag.FlagSet, string) {
+	return func(fs *flag.FlagSet, name string) { fs.DurationVar(p, name, value, "") }
+}
+
+func boolFlag(p *bool) func(*flag.FlagSet, string) {
+	return func(fs *flag.FlagSet, name string) { fs.BoolVar(p, name, false, "") }
+}
+
+func newFlagSpecs(o *cliOptions) []flagSpec {
+	browserCommands := []Action{Download, Search, List, Sync, Serve}
+	// A series picked by a title search is downloaded
+	downloadCommands := []Action{Download, Search, Sync, Serve}
+	configCommands := []Action{Download, Search, List, Sync, Serve, Doctor}
+	browserAndDoctor := []Action{Download, Search, List, Sync, Serve, Doctor}
+
+	return []flagSpec{
+		{Name: "link", Short: "l", Arg: "URL", Usage: "Target URL to scrape",
+			Commands: []Action{Download, Search, List, Sync}, bind: stringFlag(&o.link, "")},
+		{Name: "site", Arg: "URL", Usage: "Site searched by title",
+			Commands: []Action{Search}, bind: stringFlag(&o.site, DefaultSite)},
+		{Name: "pick", Arg: "N", Usage: "Download the Nth series found by a title search instead of asking",
+			Commands: []Action{Search}, bind: intFlag(&o.pick, 0)},
+		{Name: "episodes", Short: "e", Arg: "SELECTION", Usage: "Episodes to download, e.g. 1-5,8,10- or latest:3 or new,!7",
+			Commands: []Action{Download, Search}, bind: stringFlag(&o.episodes, "")},
+		{Name: "range", Short: "r", Arg: "X-Y", Usage: "Download only episodes X through Y, same as --episodes X-Y",
+			Commands: []Action{Download}, bind: stringFlag(&o.episodeRange, "")},
+		{Name: "only", Short: "o", Arg: "X,Y,Z", Usage: "Download only specific episodes, same as --episodes X,Y,Z",
+			Commands: []Action{Download}, bind: stringFlag(&o.only, "")},
+		{Name: "lang", Arg: "LANG", Usage: "Download the dubbed or subbed version of the series, or both, instead of the linked one",
+			Values:   []string{string(LangPreferITA), string(LangPreferSubITA), string(LangBoth)},
+			Commands: downloadCommands, bind: stringFlag(&o.lang, "")},
+		{Name: "all-seasons", Usage: "Also download every season and movie linked as related, each as its own season",
+			Commands: downloadCommands, bind: boolFlag(&o.allSeasons)},
+		{Name: "batch", Short: "b", Arg: "N", Usage: "Number of concurrent downloads",
+			Commands: downloadCommands, bind: intFlag(&o.batch, 3)},
+		{Name: "speed", Short: "sp", Arg: "MBPS", Usage: "Maximum total download speed in Mbps, 0 for no limit",
+			Commands: downloadCommands, bind: floatFlag(&o.speed, 0)},
+		{Name: "headless", Short: "hl", Usage: "Run the browser without a visible window (recommended)",
+			Commands: browserCommands, bind: boolFlag(&o.headless)},
+		{Name: "browser", Arg: "BROWSER", Usage: "Browser engine used for pages that need one",
+			Values:   []string{string(BrowserFirefox), string(BrowserChromium), string(BrowserWebKit)},
+			Commands: browserAndDoctor, bind: stringFlag(&o.browser, string(BrowserFirefox))},
+		{Name: "browser-path", Arg: "PATH", Usage: "Launch an installed browser instead of the one Playwright downloads", File: true,
+			Commands: browserAndDoctor, bind: stringFlag(&o.browserPath, "")},
+		{Name: "browser-args", Arg: "ARGS", Usage: "Extra command line arguments for the browser, separated by spaces",
+			Commands: browserCommands, bind: stringFlag(&o.browserArgs, "")},
+		{Name: "viewport", Arg: "WxH", Usage: "Browser window size, e.g. 1280x720",
+			Commands: browserCommands, bind: stringFlag(&o.viewport, "")},
+		{Name: "locale", Arg: "LOCALE", Usage: "Browser locale, e.g. it-IT",
+			Commands: browserCommands, bind: stringFlag(&o.locale, "")},
+		{Name: "timezone", Arg: "ZONE", Usage: "Browser time zone, e.g. Europe/Rome",
+			Commands: browserCommands, bind: stringFlag(&o.timezone, "")},
+		{Name: "skip-install", Usage: "Don't install the Playwright driver and browser, they are already there",
+			Commands: browserCommands, bind: boolFlag(&o.skipInstall)},
+		{Name: "connect", Arg: "URL", Usage: "Use a running browser instead of launching one: a Playwright server (ws://) or Chromium's DevTools (http://)",
+			Commands: browserAndDoctor, bind: stringFlag(&o.connect, "")},
+		{Name: "browser-profile", Arg: "DIR", Usage: "Keep the browser profile in DIR between runs, with its logins and cookies", File: true,
+			Commands: browserCommands, bind: stringFlag(&o.profileDir, "")},
+		{Name: "cookies", Arg: "PATH", Usage: "Load cookies from a Netscape cookies.txt file, for pages and downloads", File: true,
+			Commands: browserCommands, bind: stringFlag(&o.cookies, "")},
+		{Name: "engine", Arg: "ENGINE", Usage: "How pages are loaded: plain HTTP with the browser as a fallback, only HTTP, or only the browser",
+			Values:   []string{string(EngineAuto), string(EngineHTTP), string(EngineBrowser)},
+			Commands: browserCommands, bind: stringFlag(&o.engine, string(EngineAuto))},
+		{Name: "resolvers", Arg: "N", Usage: "Number of episode pages resolved at once, also the number of browser pages",
+			Commands: browserCommands, bind: intFlag(&o.resolvers, 4)},
+		{Name: "request-delay", Arg: "DURATION", Usage: "Minimum time between requests to the same site, e.g. 500ms",
+			Commands: browserCommands, bind: durationFlag(&o.requestDelay, 250*time.Millisecond)},
+		{Name: "debug-dir", Arg: "DIR", Usage: "Save the HTML, a screenshot, console logs and a Playwright trace of pages that don't load as expected", File: true,
+			Commands: browserCommands, bind: stringFlag(&o.debugDir, "")},
+		{Name: "resolve", Usage: "Resolve the actual video stream URLs",
+			Commands: []Action{Search}, bind: boolFlag(&o.resolve)},
+		{Name: "export", Arg: "FORMAT", Usage: "Export resolved links for an external downloader",
+			Values:   []string{string(ExportAria2), string(ExportURLs), string(ExportM3U), string(ExportCrawljob)},
+			Commands: []Action{Search}, bind: stringFlag(&o.export, "")},
+		{Name: "export-file", Arg: "PATH", Usage: "Write the export to PATH instead of stdout", File: true,
+			Commands: []Action{Search}, bind: stringFlag(&o.exportFile, "")},
+		{Name: "watchlist", Arg: "PATH", Usage: "File with one series URL per line", File: true,
+			Commands: []Action{Sync}, bind: stringFlag(&o.watchlist, defaultWatchlistPath())},
+		{Name: "listen", Arg: "ADDR", Usage: "Address the HTTP API listens on",
+			Commands: []Action{Serve}, bind: stringFlag(&o.listen, "127.0.0.1:8080")},
+		{Name: "output-dir", Short: "od", Arg: "DIR", Usage: "Directory downloads are saved to", File: true,
+			Commands: downloadCommands, bind: stringFlag(&o.outputDir, DefaultOutputDir)},
+		{Name: "template", Arg: "TEMPLATE", Usage: "Path of each episode inside the output directory, using {series}, {year}, {season}, {episode}, {special}, {lang}, {title} and {ext}",
+			Commands: downloadCommands, bind: stringFlag(&o.template, DefaultTemplate)},
+		{Name: "layout", Arg: "SERVER", Usage: "Organize downloads for a media server, with metadata and posters (overrides --template)",
+			Values:   []string{string(LayoutJellyfin), string(LayoutPlex), string(LayoutKodi)},
+			Commands: downloadCommands, bind: stringFlag(&o.layout, "")},
+		{Name: "no-tags", Usage: "Don't write metadata tags and cover art into downloaded files",
+			Commands: downloadCommands, bind: boolFlag(&o.noTags)},
+		{Name: "quality", Arg: "QUALITY", Usage: "HLS stream quality: best, worst or a maximum height like 720",
+			Commands: downloadCommands, bind: stringFlag(&o.quality, "best")},
+		{Name: "proxy", Arg: "URL", Usage: "Proxy for the browser and downloads (http, https or socks5)",
+			Commands: browserCommands, bind: stringFlag(&o.proxy, "")},
+		{Name: "profile", Short: "p", Arg: "NAME", Usage: "Apply a profile from the config file",
+			Commands: configCommands, bind: stringFlag(&o.profile, "")},
+		{Name: "config", Arg: "PATH", Usage: "Config file (default: config.yaml in the user config dir)", File: true,
+			Commands: configCommands, bind: stringFlag(&o.config, "")},
+		{Name: "output", Arg: "FORMAT", Usage: "Output format",
+			Values:   []string{string(OutputText), string(OutputJSON)},
+			Commands: []Action{Download, Search, List, Sync}, bind: stringFlag(&o.output, string(OutputText))},
+		{Name: "log-level", Arg: "LEVEL", Usage: "Log level",
+			Values: []string{"debug", "info", "warn", "error"}, bind: stringFlag(&o.logLevel, "info")},
+		{Name: "log-format", Arg: "FORMAT", Usage: "Log format",
+			Values: []string{string(LogText), string(LogJSON)}, bind: stringFlag(&o.logFormat, string(LogText))},
+		{Name: "log-file", Arg: "PATH", Usage: "Also append logs to PATH", File: true,
+			bind: stringFlag(&o.logFile, "")},
+	}
+}
+
+// errHelpShown is returned by parseArgs when help was requested and printed
+var errHelpShown = errors.New("help shown")
+
+// parseArgs parses the command line into a command, its flag values and
+// its positional arguments
+func parseArgs(args []string, stdout io.Writer) (Action, *cliOptions, []string, error) {
+	args = translateLegacyArgs(args)
+	if len(args) == 0 {
+		printUsage(stdout)
+		return Help, nil, nil, errHelpShown
+	}
+
+	name := args[0]
+	if name == "--help" || name == "-h" || name == "-help" {
+		printUsage(stdout)
+		return Help, nil, nil, errHelpShown
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		return "", nil, nil, fmt.Errorf("unknown command %q", name)
+	}
+
+	options := &cliOptions{}
+	fs := newCommandFlagSet(cmd, options)
+	positional, err := parseFlags(fs, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		printCommandHelp(stdout, cmd)
+		return cmd.Name, nil, nil, errHelpShown
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if cmd.Name == Help || cmd.Name == Completion {
+		return cmd.Name, options, positional, nil
+	}
+
+	// The config file sits below the command line, so once --config and
+	// --profile are known the flags are parsed again on top of it
+	settings, err := loadSettings(options.config, options.profile)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if len(settings) == 0 {
+		return cmd.Name, options, positional, nil
+	}
+
+	options = &cliOptions{}
+	fs = newCommandFlagSet(cmd, options)
+	if err := applySettings(fs, settings); err != nil {
+		return "", nil, nil, err
+	}
+	if positional, err = parseFlags(fs, args[1:]); err != nil {
+		return "", nil, nil, err
+	}
+	return cmd.Name, options, positional, nil
+}
+
+// newCommandFlagSet binds the flags accepted by cmd to options
+func newCommandFlagSet(cmd command, options *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(string(cmd.Name), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	// Flags of other commands are bound to a throwaway set, so their values
+	// still hold the defaults
+	unused := flag.NewFlagSet(string(cmd.Name), flag.ContinueOnError)
+	for _, spec := range newFlagSpecs(options) {
+		if !spec.appliesTo(cmd.Name) {
+			spec.bind(unused, spec.Name)
+			continue
+		}
+		spec.bind(fs, spec.Name)
+		if spec.Short != "" {
+			spec.bind(fs, spec.Short)
+		}
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and returns the positional arguments.
+// The flag package stops at the first positional argument, this keeps going
+// so flags can follow them.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// translateLegacyArgs maps the old flag-only interface (--link X --download)
+// to the equivalent subcommand, so existing scripts keep working
+func translateLegacyArgs(args []string) []string {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "-") {
+		return args
+	}
+
+	var action Action
+	var rest []string
+	for _, arg := range args {
+		switch arg {
+		case "--download", "-d":
+			action = Download
+		case "--search", "-s", "--fetch", "-f":
+			action = Search
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if action == "" {
+		return args
+	}
+	return append([]string{string(action)}, rest...)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "OtakuCrawler - Anime Web Scraper")
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  otakucrawler <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'otakucrawler help <command>' for the flags of a command.")
+}
+
+func printCommandHelp(w io.Writer, cmd command) {
+	fmt.Fprintf(w, "%s\n\n", cmd.Summary)
+	fmt.Fprintln(w, "Usage:")
+	usage := "  otakucrawler " + string(cmd.Name)
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	fmt.Fprintf(w, "%s [flags]\n", usage)
+
+	options := &cliOptions{}
+	fs := flag.NewFlagSet(string(cmd.Name), flag.ContinueOnError)
+	var lines [][2]string
+	for _, spec := range newFlagSpecs(options) {
+		if !spec.appliesTo(cmd.Name) {
+			continue
+		}
+		spec.bind(fs, spec.Name)
+
+		names := "--" + spec.Name
+		if spec.Short != "" {
+			names += ", -" + spec.Short
+		}
+		if spec.Arg != "" {
+			names += " <" + spec.Arg + ">"
+		}
+
+		usage := spec.Usage
+		if len(spec.Values) > 0 {
+			usage += ": " + strings.Join(spec.Values, ", ")
+		}
+		if def := fs.Lookup(spec.Name).DefValue; def != "" && def != "false" {
+			usage += " (default: " + def + ")"
+		}
+		lines = append(lines, [2]string{names, usage})
+	}
+	lines = append(lines, [2]string{"--help, -h", "Show this help message"})
+
+	width := 0
+	for _, line := range lines {
+		width = max(width, len(line[0]))
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	for _, line := range lines {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, line[0], line[1])
+	}
+}
+
+// showHelp handles 'otakucrawler help [command]'
+func showHelp(w io.Writer, positional []string) error {
+	if len(positional) == 0 {
+		printUsage(w)
+		return nil
+	}
+	cmd, ok := findCommand(positional[0])
+	if !ok {
+		return fmt.Errorf("unknown command %q", positional[0])
+	}
+	printCommandHelp(w, cmd)
+	return nil
+}
+
+func defaultWatchlistPath() string {
+	dir, err := appDir()
+	if err != nil {
+		return "watchlist.txt"
+	}
+	return filepath.Join(dir, "watchlist.txt")
+}
@@ -1,10 +1,13 @@
 package commons
 
 import (
+	"errors"
 	"fmt"
 	"github.com/playwright-community/playwright-go"
 	"io"
-	"log"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"os/exec"
@@ -12,55 +15,97 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Action string
 
 const (
-	Exit     Action = "exit"
-	Download Action = "download"
-	Search   Action = "search"
-	None     Action = "none"
+	Exit       Action = "exit"
+	Download   Action = "download"
+	Search     Action = "search"
+	List       Action = "list"
+	Sync       Action = "sync"
+	Serve      Action = "serve"
+	Doctor     Action = "doctor"
+	Completion Action = "completion"
+	Help       Action = "help"
 )
 
-var SupportedDomains = []string{
-	"animesaturn.*",
+// DefaultSite is searched by title when --site isn't given
+const DefaultSite = "https://www.animesaturn.cx"
+
+// SupportedDomains are the hosts of the site definitions, filled in by
+// scrapers.LoadSites. "name.*" matches the name under any top-level domain.
+var SupportedDomains []string
+
+// FranchiseSeason places a series page as one season of a franchise split
+// over several pages, so all of them land in the same show
+type FranchiseSeason struct {
+	Series string // show name shared by every season
+	Year   int    // year of the first season, 0 when unknown
+	Season int
+	// Specials of every season share season 0, so they are numbered after
+	// the ones of the seasons before
+	SpecialsBefore int
+}
+
+// FetchOptions controls how scrapers load pages
+type FetchOptions struct {
+	Engine       Engine
+	Resolvers    int           // pages loaded at once, also the size of the browser page pool
+	RequestDelay time.Duration // minimum time between requests to the same site
+	DebugDir     string        // where pages that don't load as expected are saved, empty to not save them
 }
 
 type DownloadConfig struct {
 	BatchSize    int     // number of max concurrent downloads
-	MaxSpeedMbps float64 // maximum speed in Mbps
+	MaxSpeedMbps float64 // maximum speed in Mbps, 0 for no limit
+	Quality      string  // HLS variant: "best", "worst" or a maximum height
+	OutputDir    string
+	Template     *FilenameTemplate // path of each episode inside OutputDir
+	Layout       Layout            // media server layout, replaces Template when set
+	WriteTags    bool              // write metadata tags and cover art into the files
+	Franchise    *FranchiseSeason  // set when the series is one season of a franchise
+	Events       *EventWriter      // NDJSON event stream, nil in text mode
 }
 
 type SetupResult struct {
-	Playwright       *playwright.Playwright
-	Browser          playwright.Browser
-	Page             playwright.Page
-	URL              string
-	Action           Action
-	EpisodeRange     string // Format: "start-end"
-	SpecificEpisodes string // Format: "1,3,5,7"
-	IsHeadless       bool
-	DownloadConfig   DownloadConfig
-	FFmpegPath       string
+	Browser        *Browser // started on first use, nil for commands that don't scrape
+	Fetch          FetchOptions
+	URL            string
+	Action         Action
+	Query          string           // title to search for, instead of a URL
+	Site           string           // site searched by title
+	Pick           int              // search result to download, 1-based, 0 to ask
+	Interactive    bool             // stdin and stdout are terminals, so we can ask
+	Episodes       *EpisodeSelector // episodes to download, --episodes, --range and --only combined
+	Lang           LangPreference   // versions of the series to download
+	AllSeasons     bool             // also download the related seasons and movies
+	BrowserOptions BrowserOptions
+	DownloadConfig DownloadConfig
+	FFmpegPath     string
+	Output         OutputFormat
+	Resolve        bool         // resolve stream URLs when searching
+	ExportFormat   ExportFormat // export resolved links for an external downloader
+	ExportFile     string       // where to write the export, stdout when empty
+	Watchlist      string       // series followed by sync
+	Listen         string       // address of the serve HTTP API
+	Proxy          string       // proxy URL for the browser and downloads
+	LogFile        *os.File     // closed by the caller on exit, nil when not logging to a file
 }
 
-func printHelp() {
-	fmt.Println("OtakuCrawler - Anime Web Scraper")
-	fmt.Println("Usage:")
-	fmt.Println("  --link, -l <URL>     Specify the target URL to scrape")
-	fmt.Println("  --download, -d       Download episodes from the URL")
-	fmt.Println("  --search, -s         Get streaming links without downloading")
-	fmt.Println("  --range, -r <X-Y>    Download only episodes X through Y")
-	fmt.Println("  --only, -o <X,Y,Z>   Download only specific episodes X, Y, and Z")
-	fmt.Println("  --batch, -b <N>      Number of concurrent downloads (default: 3)")
-	fmt.Println("  --speed, -sp <N>     Maximum download speed in Mbps (default: 20.0)")
-	fmt.Println("  --headless, -hl      Run browser in headless mode (no visible window, recommended)")
-	fmt.Println("  --help, -h           Show this help message")
+// appDir returns the per-user directory where OtakuCrawler keeps its files
+func appDir() (string, error) {
+	userDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userDir, "OtakuCrawler"), nil
 }
 
 func downloadFFmpeg(appDir, destPath string) error {
-	fmt.Println("Downloading FFmpeg... Please wait")
+	slog.Info("Downloading FFmpeg... Please wait")
 
 	// FFmpeg download is more complex as it comes in an archive
 	var url string
@@ -85,7 +130,7 @@ func downloadFFmpeg(appDir, destPath string) error {
 	}
 
 	// Download the archive
-	fmt.Printf("Downloading from: %s\n", url)
+	slog.Debug("Downloading FFmpeg archive", "url", url)
 	cmd := exec.Command("curl", "-L", url, "-o", archiveName)
 	setWindowsCmdAttrs(cmd)
 
@@ -94,7 +139,7 @@ func downloadFFmpeg(appDir, destPath string) error {
 	}
 
 	// Extract the archive
-	fmt.Println("Extracting FFmpeg archive...")
+	slog.Info("Extracting FFmpeg archive")
 	if strings.HasSuffix(archiveName, ".zip") {
 		// Extract ZIP
 		if runtime.GOOS == "windows" {
@@ -167,200 +212,402 @@ func downloadFFmpeg(appDir, destPath string) error {
 		}
 	}
 
-	fmt.Printf("Successfully installed FFmpeg to: %s\n", destPath)
+	slog.Info("Successfully installed FFmpeg", "path", destPath)
 	return nil
 }
 
+func localFFmpegPath(dir string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(dir, "ffmpeg.exe")
+	}
+	return filepath.Join(dir, "ffmpeg")
+}
+
 func setupFFmpeg() string {
 	// First check if ffmpeg is already in PATH
 	if _, err := exec.LookPath("ffmpeg"); err == nil {
-		fmt.Println("FFmpeg found in system PATH")
+		slog.Debug("FFmpeg found in system PATH")
 		return "ffmpeg" // Return the system ffmpeg
 	}
 
 	// Set up app directory for storing FFmpeg
-	userDir, err := os.UserConfigDir()
+	dir, err := appDir()
 	if err != nil {
-		log.Printf("Warning: Could not get user config dir: %v", err)
-		userDir = os.TempDir()
+		slog.Warn("Could not get user config dir", "error", err)
+		dir = filepath.Join(os.TempDir(), "OtakuCrawler")
 	}
-
-	appDir := filepath.Join(userDir, "OtakuCrawler")
-	if err := os.MkdirAll(appDir, 0755); err != nil {
-		log.Printf("Warning: Could not create app directory: %v", err)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Warn("Could not create app directory", "error", err)
 		return ""
 	}
 
-	ffmpegPath := filepath.Join(appDir, "ffmpeg")
-	if runtime.GOOS == "windows" {
-		ffmpegPath += ".exe"
-	}
+	ffmpegPath := localFFmpegPath(dir)
 
 	// Check if FFmpeg already exists in our app directory
 	if _, err := os.Stat(ffmpegPath); os.IsNotExist(err) {
-		fmt.Println("FFmpeg not found locally, downloading...")
-		err = downloadFFmpeg(appDir, ffmpegPath)
+		slog.Info("FFmpeg not found locally, downloading")
+		err = downloadFFmpeg(dir, ffmpegPath)
 		if err != nil {
-			log.Printf("Warning: Could not download FFmpeg: %v", err)
-			fmt.Println("⚠️  FFmpeg download failed. HLS streams will not be downloadable.")
-			fmt.Println("💡 You can manually install FFmpeg and add it to your PATH")
+			slog.Warn("FFmpeg download failed, HLS streams will not be downloadable. You can manually install FFmpeg and add it to your PATH", "error", err)
 			return ""
 		}
 	} else {
-		fmt.Printf("Using local FFmpeg at: %s\n", ffmpegPath)
+		slog.Debug("Using local FFmpeg", "path", ffmpegPath)
 	}
 
 	return ffmpegPath
 }
 
-func CommonSetup() SetupResult {
-	var link string
-	var action = None
-	var episodeRange string
-	var specificEpisodes string
-	var isHeadless = false
+// ParseArgs parses and validates the command line and sets up logging.
+// Help and completion scripts are printed here, the returned Action is
+// Exit when there is nothing left to do.
+func ParseArgs(args []string) (SetupResult, error) {
+	action, options, positional, err := parseArgs(args, os.Stdout)
+	if errors.Is(err, errHelpShown) {
+		return SetupResult{Action: Exit}, nil
+	}
+	if err != nil {
+		return SetupResult{}, err
+	}
 
-	downloadConfig := DownloadConfig{
-		BatchSize:    3,
-		MaxSpeedMbps: 1000.0,
-	}
-
-	args := os.Args[1:]
-
-	if len(args) == 0 {
-		printHelp()
-		return SetupResult{Action: Exit}
-	}
-
-	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "--help", "-h":
-			printHelp()
-			return SetupResult{Action: Exit}
-		case "--link", "-l":
-			if i+1 < len(args) {
-				link = args[i+1]
-				i++
-			} else {
-				log.Fatal("Error: --link requires a URL argument")
-			}
-		case "--download", "-d":
-			if action != None {
-				log.Fatal("Error: Multiple actions specified. Choose one: --download, --search, or --fetch.")
-			}
-			action = Download
-		case "--search", "-s", "--fetch", "-f":
-			if action != None {
-				log.Fatal("Error: Multiple actions specified. Choose one: --download, --search, or --fetch.")
-			}
-			action = Search
-		case "--range", "-r":
-			if i+1 < len(args) {
-				episodeRange = args[i+1]
-				i++
-			} else {
-				log.Fatal("Error: --range requires a value in the format 'start-end'")
-			}
-		case "--only", "-o":
-			if i+1 < len(args) {
-				specificEpisodes = args[i+1]
-				i++
-			} else {
-				log.Fatal("Error: --only requires a comma-separated list of episode numbers")
-			}
-		case "--batch", "-b":
-			if i+1 < len(args) {
-				batchSize, err := strconv.Atoi(args[i+1])
-				if err != nil || batchSize < 1 {
-					log.Fatal("Error: --batch requires a positive integer")
-				}
-				downloadConfig.BatchSize = batchSize
-				i++
-			} else {
-				log.Fatal("Error: --batch requires a positive integer argument")
-			}
-		case "--speed", "-sp":
-			if i+1 < len(args) {
-				speed, err := strconv.ParseFloat(args[i+1], 64)
-				if err != nil || speed <= 0 {
-					log.Fatal("Error: --speed requires a positive number")
-				}
-				downloadConfig.MaxSpeedMbps = speed
-				i++
-			} else {
-				log.Fatal("Error: --speed requires a positive number argument")
-			}
-		case "--headless", "-hl":
-			isHeadless = true
-		default:
-			log.Fatalf("Unknown argument: %s\nUse --help to see usage.", args[i])
+	switch action {
+	case Help:
+		return SetupResult{Action: Exit}, showHelp(os.Stdout, positional)
+	case Completion:
+		if len(positional) != 1 {
+			return SetupResult{}, fmt.Errorf("completion requires a shell argument (bash, zsh or fish)")
+		}
+		script, err := completionScript(positional[0])
+		if err != nil {
+			return SetupResult{}, err
 		}
+		fmt.Print(script)
+		return SetupResult{Action: Exit}, nil
 	}
 
-	// Make sure both --range and --only are not specified together
-	if episodeRange != "" && specificEpisodes != "" {
-		log.Fatal("Error: Cannot use both --range and --only at the same time")
+	// Only search takes a positional argument, the title. It doesn't need
+	// quotes, the words are joined back together.
+	var query string
+	if action == Search {
+		query = strings.Join(positional, " ")
+	} else if len(positional) > 0 {
+		return SetupResult{}, fmt.Errorf("unexpected argument %q", positional[0])
 	}
 
-	if link == "" {
-		log.Fatal("Error: No link provided. Use --link or -l followed by a URL.")
+	logLevel, err := parseLogLevel(options.logLevel)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	logFormat, err := parseLogFormat(options.logFormat)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	output, err := parseOutputFormat(options.output)
+	if err != nil {
+		return SetupResult{}, err
 	}
 
-	if !isSupportedLink(link) {
-		log.Fatalf("Error: Link is not from a supported domain.\nSupported domains: %v", SupportedDomains)
+	exportFormat := ExportNone
+	if options.export != "" {
+		exportFormat, err = parseExportFormat(options.export)
+		if err != nil {
+			return SetupResult{}, err
+		}
 	}
 
-	fmt.Printf("Action: %s, URL: %s\n", action, link)
-	if action == Download {
-		fmt.Printf("Download Config: Batch Size: %d, Max Speed: %.1f Mbps\n",
-			downloadConfig.BatchSize, downloadConfig.MaxSpeedMbps)
+	if options.batch < 1 {
+		return SetupResult{}, fmt.Errorf("--batch requires a positive integer")
+	}
+	if options.speed < 0 {
+		return SetupResult{}, fmt.Errorf("--speed requires a positive number, or 0 for no limit")
 	}
 
-	if action == None {
-		os.Exit(0)
+	template, err := ParseFilenameTemplate(options.template)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	layout, err := parseLayout(options.layout)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	if options.outputDir == "" {
+		return SetupResult{}, fmt.Errorf("--output-dir can't be empty")
 	}
 
-	// Install dependencies (Playwright and FFmpeg)
-	if !installDeps() {
-		return SetupResult{Action: Exit}
+	engine, err := parseEngine(options.engine)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	if options.resolvers < 1 {
+		return SetupResult{}, fmt.Errorf("--resolvers requires a positive integer")
+	}
+	if options.requestDelay < 0 {
+		return SetupResult{}, fmt.Errorf("--request-delay can't be negative")
+	}
+	browserOptions, err := parseBrowserOptions(options)
+	if err != nil {
+		return SetupResult{}, err
+	}
+	fetchOptions := FetchOptions{
+		Engine:       engine,
+		Resolvers:    options.resolvers,
+		RequestDelay: options.requestDelay,
+		DebugDir:     options.debugDir,
 	}
 
-	// Setup FFmpeg
-	ffmpegPath := setupFFmpeg()
+	lang, err := parseLangPreference(options.lang)
+	if err != nil {
+		return SetupResult{}, err
+	}
 
-	pw, err := playwright.Run(&playwright.RunOptions{Browsers: []string{"firefox"}})
+	quality, err := parseQuality(options.quality)
 	if err != nil {
-		log.Fatalf("could not start playwright: %v", err)
+		return SetupResult{}, err
+	}
+	if options.proxy != "" {
+		if _, err := parseProxy(options.proxy); err != nil {
+			return SetupResult{}, err
+		}
 	}
 
-	browser, err := pw.Firefox.Launch(playwright.BrowserTypeLaunchOptions{
-		Headless: playwright.Bool(isHeadless),
-	})
+	// --range and --only are kept as shorthands of --episodes
+	episodes, err := ParseEpisodeSelector(JoinEpisodeSelections(options.episodes, options.episodeRange, options.only))
 	if err != nil {
-		log.Fatalf("could not launch browser: %v", err)
+		return SetupResult{}, err
 	}
 
-	page, err := browser.NewPage()
+	switch {
+	case action == Search && query != "":
+		if options.link != "" {
+			return SetupResult{}, fmt.Errorf("search takes either a title or --link, not both")
+		}
+		if options.resolve || exportFormat != ExportNone {
+			return SetupResult{}, fmt.Errorf("--resolve and --export need --link, they work on the episodes of a series")
+		}
+	case action == Search && options.link == "":
+		return SetupResult{}, fmt.Errorf("search needs a title, or a series URL with --link")
+	case action == Download || action == List:
+		if options.link == "" {
+			return SetupResult{}, fmt.Errorf("no link provided, use --link or -l followed by a URL")
+		}
+	}
+	if options.pick < 0 {
+		return SetupResult{}, fmt.Errorf("--pick requires a positive integer")
+	}
+	if options.pick > 0 && query == "" {
+		return SetupResult{}, fmt.Errorf("--pick only applies to a search by title")
+	}
+
+	// Exports are only useful with the actual stream URLs
+	resolve := options.resolve
+	if exportFormat != ExportNone {
+		if options.exportFile == "" && output == OutputJSON {
+			return SetupResult{}, fmt.Errorf("--export with --output json requires --export-file")
+		}
+		resolve = true
+	}
+
+	logFile, err := setupLogger(logLevel, logFormat, options.logFile)
 	if err != nil {
-		log.Fatalf("could not create page: %v", err)
+		return SetupResult{}, err
 	}
 
-	if _, err = page.Goto(link); err != nil {
-		log.Fatalf("could not goto: %v", err)
+	downloadConfig := DownloadConfig{
+		BatchSize:    options.batch,
+		MaxSpeedMbps: options.speed,
+		Quality:      quality,
+		OutputDir:    options.outputDir,
+		Template:     template,
+		Layout:       layout,
+		WriteTags:    !options.noTags,
+	}
+	// Both versions would be saved to the same files and the second one
+	// skipped as already downloaded
+	if lang == LangBoth && !downloadConfig.SeparatesLanguages() {
+		return SetupResult{}, fmt.Errorf("--lang both needs {lang} in --template, otherwise both versions are saved to the same files")
+	}
+
+	// In JSON mode stdout only carries machine-readable output, everything
+	// meant for humans goes to stderr through the logger
+	if output == OutputJSON {
+		downloadConfig.Events = NewEventWriter(os.Stdout)
 	}
 
 	return SetupResult{
-		Playwright:       pw,
-		Browser:          browser,
-		Page:             page,
-		URL:              link,
-		Action:           action,
-		EpisodeRange:     episodeRange,
-		SpecificEpisodes: specificEpisodes,
-		IsHeadless:       isHeadless,
-		DownloadConfig:   downloadConfig,
-		FFmpegPath:       ffmpegPath,
+		URL:            options.link,
+		Action:         action,
+		Query:          query,
+		Site:           options.site,
+		Pick:           options.pick,
+		Interactive:    isTerminal(os.Stdin) && isTerminal(os.Stdout),
+		Episodes:       episodes,
+		Lang:           lang,
+		AllSeasons:     options.allSeasons,
+		BrowserOptions: browserOptions,
+		Fetch:          fetchOptions,
+		DownloadConfig: downloadConfig,
+		Output:         output,
+		Resolve:        resolve,
+		ExportFormat:   exportFormat,
+		ExportFile:     options.exportFile,
+		Watchlist:      options.watchlist,
+		Listen:         options.listen,
+		Proxy:          options.proxy,
+		LogFile:        logFile,
+	}, nil
+}
+
+// CheckSupportedSites checks that the link, or the site searched by title,
+// belongs to one of the SupportedDomains. It runs once the site definitions
+// are loaded, which ParseArgs doesn't wait for.
+func CheckSupportedSites(setup SetupResult) error {
+	if setup.Action == Search && setup.Query != "" && !isSupportedLink(setup.Site) {
+		return fmt.Errorf("--site is not a supported domain, supported domains: %v", SupportedDomains)
 	}
+	if setup.URL != "" && !isSupportedLink(setup.URL) {
+		return fmt.Errorf("link is not from a supported domain, supported domains: %v", SupportedDomains)
+	}
+	return nil
+}
+
+// CommonSetup prepares FFmpeg, the proxy and the browser for the parsed
+// command. The browser itself is launched when first needed.
+func CommonSetup(setup SetupResult) (SetupResult, error) {
+	cmd, _ := findCommand(string(setup.Action))
+	if !cmd.Browser {
+		return setup, nil
+	}
+
+	slog.Info("Starting", "action", setup.Action, "url", setup.URL)
+	if setup.Action == Download || setup.Action == Sync || setup.Action == Serve {
+		slog.Info("Download config", "batch_size", setup.DownloadConfig.BatchSize, "max_speed_mbps", setup.DownloadConfig.MaxSpeedMbps)
+	}
+
+	// FFmpeg is only needed to download
+	if setup.Action != List {
+		setup.FFmpegPath = setupFFmpeg()
+	}
+
+	var proxy *playwright.Proxy
+	if setup.Proxy != "" {
+		// Pages and downloads go through the default HTTP transport
+		proxyURL, _ := parseProxy(setup.Proxy)
+		if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		proxy = browserProxy(proxyURL)
+		slog.Info("Using proxy", "proxy", proxyURL.Redacted())
+	}
+
+	// Pages and downloads share the cookies of the file and the profile
+	if len(setup.BrowserOptions.Cookies) > 0 || setup.BrowserOptions.Profile != "" {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return setup, fmt.Errorf("could not create cookie jar: %w", err)
+		}
+		setJarCookies(jar, setup.BrowserOptions.Cookies)
+		http.DefaultClient.Jar = jar
+		setup.BrowserOptions.Jar = jar
+		slog.Info("Using cookies", "count", len(setup.BrowserOptions.Cookies), "profile", setup.BrowserOptions.Profile)
+	}
+
+	// The session of a profile lives in the browser, so pages are loaded
+	// there from the start
+	if setup.BrowserOptions.Profile != "" && setup.Fetch.Engine == EngineAuto {
+		setup.Fetch.Engine = EngineBrowser
+	}
+
+	// Playwright and Firefox are only installed and started once a page
+	// needs them
+	setup.BrowserOptions.Proxy = proxy
+	setup.Browser = newBrowser(setup.BrowserOptions, setup.Fetch.Resolvers)
+	return setup, nil
+}
+
+func parseBrowserOptions(options *cliOptions) (BrowserOptions, error) {
+	browser, err := parseBrowserType(options.browser)
+	if err != nil {
+		return BrowserOptions{}, err
+	}
+	browserOptions := BrowserOptions{
+		Type:        browser,
+		Headless:    options.headless,
+		Executable:  options.browserPath,
+		Args:        strings.Fields(options.browserArgs),
+		Locale:      options.locale,
+		Timezone:    options.timezone,
+		SkipInstall: options.skipInstall,
+		Profile:     options.profileDir,
+		Trace:       options.debugDir != "",
+	}
+	if options.browserPath != "" {
+		if _, err := os.Stat(options.browserPath); err != nil {
+			return BrowserOptions{}, fmt.Errorf("--browser-path: %w", err)
+		}
+	}
+	if options.viewport != "" {
+		if browserOptions.Viewport, err = parseViewport(options.viewport); err != nil {
+			return BrowserOptions{}, err
+		}
+	}
+	if options.connect != "" {
+		// Launch options don't reach a browser running elsewhere
+		if options.profileDir != "" || options.browserPath != "" || options.browserArgs != "" {
+			return BrowserOptions{}, fmt.Errorf("--connect can't be combined with --browser-profile, --browser-path or --browser-args")
+		}
+		if browserOptions.Endpoint, err = parseEndpoint(options.connect, browser); err != nil {
+			return BrowserOptions{}, err
+		}
+	}
+	if options.cookies != "" {
+		if browserOptions.Cookies, err = loadCookiesFile(options.cookies); err != nil {
+			return BrowserOptions{}, err
+		}
+	}
+	return browserOptions, nil
+}
+
+// parseQuality accepts "best", "worst" or a maximum height like 720 or 720p
+func parseQuality(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "", "best":
+		return "best", nil
+	case "worst":
+		return "worst", nil
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(value, "p"))
+	if err != nil || height <= 0 {
+		return "", fmt.Errorf("unknown quality %q, expected 'best', 'worst' or a height like 720", value)
+	}
+	return strconv.Itoa(height), nil
+}
+
+func parseProxy(value string) (*url.URL, error) {
+	proxyURL, err := url.Parse(value)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, expected a URL like http://host:port", value)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+		return proxyURL, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", proxyURL.Scheme)
+	}
+}
+
+// browserProxy moves the credentials of proxyURL to the fields Playwright
+// expects them in
+func browserProxy(proxyURL *url.URL) *playwright.Proxy {
+	server := *proxyURL
+	server.User = nil
+	proxy := &playwright.Proxy{Server: server.String()}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		proxy.Username = playwright.String(proxyURL.User.Username())
+		proxy.Password = playwright.String(password)
+	}
+	return proxy
 }
 
 func isSupportedLink(link string) bool {
@@ -371,58 +618,74 @@ func isSupportedLink(link string) bool {
 	host := strings.TrimPrefix(parsedURL.Hostname(), "www.")
 
 	for _, domain := range SupportedDomains {
-		if strings.HasSuffix(domain, ".*") {
-			base := strings.TrimSuffix(domain, ".*")
-			if strings.HasPrefix(host, base+".") || host == base {
-				return true
-			}
-		} else {
-			if host == domain {
-				return true
-			}
+		if MatchesDomain(host, domain) {
+			return true
 		}
 	}
 	return false
 }
 
-func installDeps() bool {
-	fmt.Println("Installing dependencies.. Please wait")
+// MatchesDomain reports whether host, without www., is domain or one of its
+// "name.*" forms
+func MatchesDomain(host, domain string) bool {
+	host, domain = strings.ToLower(host), strings.ToLower(domain)
+	if base, ok := strings.CutSuffix(domain, ".*"); ok {
+		return strings.HasPrefix(host, base+".") || host == base
+	}
+	return host == domain
+}
+
+func installDeps(options BrowserOptions) error {
+	if options.SkipInstall {
+		slog.Debug("Skipping dependency install")
+		return nil
+	}
+
+	slog.Info("Installing dependencies.. Please wait")
+	// The driver prints its progress on stdout, which is reserved for results.
+	// A browser of our own or a remote one only needs the driver.
 	err := playwright.Install(&playwright.RunOptions{
-		Browsers: []string{"firefox"},
+		Browsers:            []string{string(options.Type)},
+		SkipInstallBrowsers: options.Executable != "" || options.Endpoint != "",
+		Stdout:              os.Stderr,
 	})
 	if err != nil {
-		log.Fatalf("could not install playwright: %v", err)
+		return fmt.Errorf("could not install playwright: %w", err)
 	}
 
-	if runtime.GOOS == "windows" {
-		checkDLL := func(name string) bool {
-			_, err := exec.LookPath(name)
-			return err == nil
+	if options.Type != BrowserFirefox || options.Endpoint != "" {
+		slog.Info("Successfully installed dependencies")
+		return nil
+	}
+	if missing := missingMediaFoundation(); len(missing) > 0 {
+		fmt.Fprintln(os.Stderr, "\n⚠️  Missing Media Foundation components:")
+		for _, dll := range missing {
+			fmt.Fprintf(os.Stderr, " - %s\n", dll)
 		}
+		fmt.Fprintln(os.Stderr, "\nPlease install the Media Feature Pack:")
+		fmt.Fprintln(os.Stderr, "🔗 https://support.microsoft.com/en-us/help/3145500/media-feature-pack-list-for-windows-n-editions")
+
+		fmt.Fprintln(os.Stderr, "\nIf you're on Windows Server, run this as Administrator in PowerShell:")
+		fmt.Fprintln(os.Stderr, "  Install-WindowsFeature Server-Media-Foundation")
+		return fmt.Errorf("missing Media Foundation components: %v", missing)
+	}
 
-		requiredDLLs := []string{"mf.dll", "mfplat.dll"}
-		var missing []string
+	slog.Info("Successfully installed dependencies")
+	return nil
+}
 
-		for _, dll := range requiredDLLs {
-			if !checkDLL(dll) {
-				missing = append(missing, dll)
-			}
-		}
+// missingMediaFoundation returns the Media Foundation DLLs Firefox needs
+// for video playback that are missing, always empty outside Windows
+func missingMediaFoundation() []string {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
 
-		if len(missing) > 0 {
-			fmt.Println("\n⚠️  Missing Media Foundation components:")
-			for _, dll := range missing {
-				fmt.Printf(" - %s\n", dll)
-			}
-			fmt.Println("\nPlease install the Media Feature Pack:")
-			fmt.Println("🔗 https://support.microsoft.com/en-us/help/3145500/media-feature-pack-list-for-windows-n-editions")
-
-			fmt.Println("\nIf you're on Windows Server, run this as Administrator in PowerShell:")
-			fmt.Println("  Install-WindowsFeature Server-Media-Foundation")
-			return false
+	var missing []string
+	for _, dll := range []string{"mf.dll", "mfplat.dll"} {
+		if _, err := exec.LookPath(dll); err != nil {
+			missing = append(missing, dll)
 		}
 	}
-
-	fmt.Println("Successfully installed dependencies")
-	return true
+	return missing
 }
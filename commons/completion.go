@@ -0,0 +1,197 @@
+package commons
+
+import (
+	"fmt"
+	"strings"
+)
+
+// completionScript generates a completion script for shell from the
+// command and flag definitions
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion(), nil
+	case "zsh":
+		return zshCompletion(), nil
+	case "fish":
+		return fishCompletion(), nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q, expected 'bash', 'zsh' or 'fish'", shell)
+	}
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, string(cmd.Name))
+	}
+	return names
+}
+
+func bashCompletion() string {
+	specs := newFlagSpecs(&cliOptions{})
+	var sb strings.Builder
+
+	sb.WriteString("# bash completion for otakucrawler\n")
+	sb.WriteString("_otakucrawler() {\n")
+	sb.WriteString("    local cur prev\n")
+	sb.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	sb.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n\n")
+	fmt.Fprintf(&sb, "    if [[ $COMP_CWORD -eq 1 ]]; then\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n        return\n    fi\n\n",
+		strings.Join(commandNames(), " "))
+
+	// Values of the previous flag
+	sb.WriteString("    case \"$prev\" in\n")
+	for _, spec := range specs {
+		if spec.Arg == "" {
+			continue
+		}
+		patterns := "--" + spec.Name
+		if spec.Short != "" {
+			patterns += "|-" + spec.Short
+		}
+		switch {
+		case len(spec.Values) > 0:
+			fmt.Fprintf(&sb, "        %s)\n            COMPREPLY=($(compgen -W %q -- \"$cur\"))\n            return ;;\n",
+				patterns, strings.Join(spec.Values, " "))
+		case spec.File:
+			fmt.Fprintf(&sb, "        %s)\n            COMPREPLY=($(compgen -f -- \"$cur\"))\n            return ;;\n", patterns)
+		default:
+			fmt.Fprintf(&sb, "        %s)\n            return ;;\n", patterns)
+		}
+	}
+	sb.WriteString("    esac\n\n")
+
+	// Flags of the current command
+	sb.WriteString("    case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range commands {
+		switch cmd.Name {
+		case Completion:
+			sb.WriteString("        completion)\n            COMPREPLY=($(compgen -W \"bash zsh fish\" -- \"$cur\")) ;;\n")
+			continue
+		case Help:
+			fmt.Fprintf(&sb, "        help)\n            COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(commandNames(), " "))
+			continue
+		}
+		var flags []string
+		for _, spec := range specs {
+			if spec.appliesTo(cmd.Name) {
+				flags = append(flags, "--"+spec.Name)
+			}
+		}
+		flags = append(flags, "--help")
+		fmt.Fprintf(&sb, "        %s)\n            COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", cmd.Name, strings.Join(flags, " "))
+	}
+	sb.WriteString("    esac\n")
+	sb.WriteString("}\n")
+	sb.WriteString("complete -F _otakucrawler otakucrawler\n")
+	return sb.String()
+}
+
+func zshCompletion() string {
+	specs := newFlagSpecs(&cliOptions{})
+	var sb strings.Builder
+
+	sb.WriteString("#compdef otakucrawler\n\n")
+	sb.WriteString("_otakucrawler() {\n")
+	sb.WriteString("    local -a commands\n")
+	sb.WriteString("    commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&sb, "        '%s:%s'\n", cmd.Name, zshEscape(cmd.Summary))
+	}
+	sb.WriteString("    )\n\n")
+	sb.WriteString("    if (( CURRENT == 2 )); then\n        _describe 'command' commands\n        return\n    fi\n\n")
+
+	sb.WriteString("    case $words[2] in\n")
+	for _, cmd := range commands {
+		switch cmd.Name {
+		case Completion:
+			sb.WriteString("        completion)\n            _values 'shell' bash zsh fish ;;\n")
+			continue
+		case Help:
+			sb.WriteString("        help)\n            _describe 'command' commands ;;\n")
+			continue
+		}
+		fmt.Fprintf(&sb, "        %s)\n            _arguments \\\n", cmd.Name)
+		for _, spec := range specs {
+			if !spec.appliesTo(cmd.Name) {
+				continue
+			}
+			action := ""
+			if spec.Arg != "" {
+				action = ":" + strings.ToLower(spec.Arg) + ":"
+				switch {
+				case len(spec.Values) > 0:
+					action += "(" + strings.Join(spec.Values, " ") + ")"
+				case spec.File:
+					action += "_files"
+				}
+			}
+			description := "[" + zshEscape(spec.Usage) + "]"
+			if spec.Short != "" {
+				fmt.Fprintf(&sb, "                '(-%s --%s)'{-%s,--%s}'%s%s' \\\n",
+					spec.Short, spec.Name, spec.Short, spec.Name, description, action)
+			} else {
+				fmt.Fprintf(&sb, "                '--%s%s%s' \\\n", spec.Name, description, action)
+			}
+		}
+		sb.WriteString("                '--help[Show help]' ;;\n")
+	}
+	sb.WriteString("    esac\n")
+	sb.WriteString("}\n\n")
+	sb.WriteString("compdef _otakucrawler otakucrawler\n")
+	return sb.String()
+}
+
+// zshEscape makes text safe inside a single-quoted _arguments spec
+func zshEscape(text string) string {
+	replacer := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	return replacer.Replace(text)
+}
+
+func fishCompletion() string {
+	specs := newFlagSpecs(&cliOptions{})
+	var sb strings.Builder
+
+	sb.WriteString("# fish completion for otakucrawler\n")
+	sb.WriteString("complete -c otakucrawler -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&sb, "complete -c otakucrawler -n __fish_use_subcommand -a %s -d %s\n",
+			cmd.Name, fishQuote(cmd.Summary))
+	}
+	fmt.Fprintf(&sb, "complete -c otakucrawler -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n")
+	fmt.Fprintf(&sb, "complete -c otakucrawler -n '__fish_seen_subcommand_from help' -a %s\n",
+		fishQuote(strings.Join(commandNames(), " ")))
+
+	for _, spec := range specs {
+		var cmds []string
+		for _, cmd := range commands {
+			if cmd.Name != Completion && cmd.Name != Help && spec.appliesTo(cmd.Name) {
+				cmds = append(cmds, string(cmd.Name))
+			}
+		}
+
+		line := fmt.Sprintf("complete -c otakucrawler -n '__fish_seen_subcommand_from %s' -l %s",
+			strings.Join(cmds, " "), spec.Name)
+		if spec.Short != "" {
+			// -o allows multi-letter single dash options like -sp
+			line += " -o " + spec.Short
+		}
+		if spec.Arg != "" {
+			line += " -r"
+			switch {
+			case len(spec.Values) > 0:
+				line += " -a " + fishQuote(strings.Join(spec.Values, " "))
+			case spec.File:
+				line += " -F"
+			}
+		}
+		line += " -d " + fishQuote(spec.Usage)
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+func fishQuote(text string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(text, `\`, `\\`), "'", `\'`) + "'"
+}
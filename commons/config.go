@@ -0,0 +1,134 @@
+package commons
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// notConfigurable are flags that only make sense for a single run
+var notConfigurable = []string{"link", "pick", "episodes", "range", "only", "profile", "config"}
+
+func defaultConfigPath() string {
+	dir, err := appDir()
+	if err != nil {
+		return "config.yaml"
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+// SitesDir is the folder of site definitions read over the built-in ones,
+// empty when there is no config dir
+func SitesDir() string {
+	dir, err := appDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sites")
+}
+
+// loadSettings reads the config file and returns the flag values it sets,
+// with the named profile applied over the top-level values.
+// A missing config file is only an error when it was asked for explicitly.
+func loadSettings(path, profile string) (map[string]string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		if profile != "" {
+			return nil, fmt.Errorf("profile %q requested but there is no config file at %s", profile, path)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse config %s: %w", path, err)
+	}
+
+	var profiles map[string]any
+	if value, ok := raw["profiles"]; ok {
+		profiles, ok = value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("config %s: profiles must be a mapping of profile names", path)
+		}
+		delete(raw, "profiles")
+	}
+
+	settings := map[string]string{}
+	if err := flattenSettings(settings, raw); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	if profile != "" {
+		values, ok := profiles[profile].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("config %s: unknown profile %q", path, profile)
+		}
+		if err := flattenSettings(settings, values); err != nil {
+			return nil, fmt.Errorf("config %s: profile %q: %w", path, profile, err)
+		}
+	}
+	return settings, nil
+}
+
+// flattenSettings converts YAML values to flag values. Keys are flag names,
+// underscores are accepted in place of dashes.
+func flattenSettings(settings map[string]string, values map[string]any) error {
+	known := map[string]bool{}
+	for _, spec := range newFlagSpecs(&cliOptions{}) {
+		known[spec.Name] = !slices.Contains(notConfigurable, spec.Name)
+	}
+
+	for key, value := range values {
+		name := strings.ReplaceAll(key, "_", "-")
+		configurable, ok := known[name]
+		if !ok {
+			return fmt.Errorf("unknown option %q", key)
+		}
+		if !configurable {
+			return fmt.Errorf("option %q can't be set in the config file, pass it on the command line", key)
+		}
+
+		switch v := value.(type) {
+		case nil:
+			continue
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			settings[name] = strings.Join(items, ",")
+		case map[string]any:
+			return fmt.Errorf("option %q must be a single value", key)
+		default:
+			settings[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// applySettings sets the config values on fs before the command line is
+// parsed, so flags still win. Options of other commands are ignored.
+func applySettings(fs *flag.FlagSet, settings map[string]string) error {
+	for name, value := range settings {
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config: invalid value %q for %s: %w", value, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package commons
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/playwright-community/playwright-go"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in cookies.txt files exported by
+// browsers, on lines that would otherwise be comments
+const httpOnlyPrefix = "#HttpOnly_"
+
+// loadCookiesFile reads cookies in the Netscape cookies.txt format, as
+// exported by browser extensions and used by curl and yt-dlp. Domains
+// keep their leading dot when the cookie applies to subdomains too.
+func loadCookiesFile(path string) ([]*http.Cookie, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open cookies file: %w", err)
+	}
+	defer file.Close()
+
+	var cookies []*http.Cookie
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies file %s:%d: expected 7 tab separated fields, got %d", path, lineNumber, len(fields))
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			domain = "." + domain
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies file %s:%d: invalid expiry %q", path, lineNumber, fields[4])
+		}
+
+		cookie := &http.Cookie{
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		// 0 is a session cookie
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read cookies file: %w", err)
+	}
+	return cookies, nil
+}
+
+// setJarCookies adds cookies to jar, each under the site it belongs to
+func setJarCookies(jar http.CookieJar, cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		host := strings.TrimPrefix(cookie.Domain, ".")
+		// The jar only keeps a Domain attribute for cookies shared with
+		// subdomains, others belong to the host alone
+		jarCookie := *cookie
+		if !strings.HasPrefix(cookie.Domain, ".") {
+			jarCookie.Domain = ""
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{&jarCookie})
+	}
+}
+
+// browserCookies converts cookies for a browser context
+func browserCookies(cookies []*http.Cookie) []playwright.OptionalCookie {
+	converted := make([]playwright.OptionalCookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		browserCookie := playwright.OptionalCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   playwright.String(cookie.Domain),
+			Path:     playwright.String(cookie.Path),
+			HttpOnly: playwright.Bool(cookie.HttpOnly),
+			Secure:   playwright.Bool(cookie.Secure),
+		}
+		if !cookie.Expires.IsZero() {
+			browserCookie.Expires = playwright.Float(float64(cookie.Expires.Unix()))
+		}
+		converted = append(converted, browserCookie)
+	}
+	return converted
+}
+
+// jarCookies converts cookies of a browser context, so plain HTTP requests
+// and downloads carry the browser session
+func jarCookies(cookies []playwright.Cookie) []*http.Cookie {
+	converted := make([]*http.Cookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		jarCookie := &http.Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			HttpOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		// -1 is a session cookie
+		if cookie.Expires > 0 {
+			jarCookie.Expires = time.Unix(int64(cookie.Expires), 0)
+		}
+		converted = append(converted, jarCookie)
+	}
+	return converted
+}
@@ -0,0 +1,128 @@
+package commons
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/playwright-community/playwright-go"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+type doctorCheck struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// RunDoctor checks that everything the scrapers need is installed and
+// working, without installing or downloading anything. checkSites reads the
+// site definitions, which live with the scrapers.
+func RunDoctor(ctx context.Context, w io.Writer, browser BrowserOptions, checkSites func(ctx context.Context) (string, error)) error {
+	checks := []doctorCheck{
+		{Name: "Site definitions", Run: checkSites},
+		{Name: "FFmpeg", Run: checkFFmpeg},
+		{Name: "Playwright driver", Run: checkPlaywrightDriver},
+		{Name: browserName(browser), Run: func(ctx context.Context) (string, error) { return checkBrowser(ctx, browser) }},
+	}
+	if runtime.GOOS == "windows" && browser.Type == BrowserFirefox && browser.Endpoint == "" {
+		checks = append(checks, doctorCheck{Name: "Media Foundation", Run: checkMediaFoundation})
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		detail, err := check.Run(ctx)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "❌ %s: %v\n", check.Name, err)
+			continue
+		}
+		fmt.Fprintf(w, "✅ %s: %s\n", check.Name, detail)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func checkFFmpeg(ctx context.Context) (string, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		dir, err := appDir()
+		if err != nil {
+			return "", fmt.Errorf("not in PATH and could not get user config dir: %w", err)
+		}
+		path = localFFmpegPath(dir)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("not in PATH or %s, it will be downloaded on the first run", dir)
+		}
+	}
+
+	output, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("could not run %s: %w", path, err)
+	}
+	version, _, _ := strings.Cut(string(output), "\n")
+	return fmt.Sprintf("%s (%s)", path, strings.TrimSpace(version)), nil
+}
+
+func checkPlaywrightDriver(ctx context.Context) (string, error) {
+	driver, err := playwright.NewDriver(&playwright.RunOptions{Stdout: os.Stderr})
+	if err != nil {
+		return "", fmt.Errorf("could not get driver: %w", err)
+	}
+
+	output, err := driver.Command("--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("not installed, it will be installed on the first run: %w", err)
+	}
+	if !bytes.Contains(output, []byte(driver.Version)) {
+		return "", fmt.Errorf("found %s, expected %s", strings.TrimSpace(string(output)), driver.Version)
+	}
+	return driver.Version, nil
+}
+
+func browserName(options BrowserOptions) string {
+	name := map[BrowserType]string{BrowserFirefox: "Firefox", BrowserChromium: "Chromium", BrowserWebKit: "WebKit"}[options.Type]
+	switch {
+	case options.Endpoint != "":
+		name += " (" + options.Endpoint + ")"
+	case options.Executable != "":
+		name += " (" + options.Executable + ")"
+	}
+	return name
+}
+
+func checkBrowser(ctx context.Context, options BrowserOptions) (string, error) {
+	if _, err := checkPlaywrightDriver(ctx); err != nil {
+		return "", fmt.Errorf("needs the Playwright driver")
+	}
+
+	pw, err := playwright.Run(&playwright.RunOptions{Browsers: []string{string(options.Type)}, Stdout: os.Stderr})
+	if err != nil {
+		return "", fmt.Errorf("could not start playwright: %w", err)
+	}
+	defer pw.Stop()
+
+	options.Headless = true
+	browser, err := openBrowser(pw, options)
+	if err != nil {
+		return "", err
+	}
+	defer browser.Close()
+
+	return browser.Version(), nil
+}
+
+func checkMediaFoundation(ctx context.Context) (string, error) {
+	if missing := missingMediaFoundation(); len(missing) > 0 {
+		return "", fmt.Errorf("missing %s, install the Media Feature Pack", strings.Join(missing, ", "))
+	}
+	return "installed", nil
+}
@@ -0,0 +1,41 @@
+package commons
+
+import "fmt"
+
+// Languages of a series as labelled by the sites
+const (
+	LangSubITA = "SUB_ITA" // original audio with Italian subtitles
+	LangITA    = "ITA"     // Italian dub
+)
+
+// LangPreference chooses between the dubbed and subbed versions of a series
+type LangPreference string
+
+const (
+	LangAsLinked     LangPreference = "" // only the version that was linked
+	LangPreferITA    LangPreference = "ita"
+	LangPreferSubITA LangPreference = "sub-ita"
+	LangBoth         LangPreference = "both"
+)
+
+func parseLangPreference(value string) (LangPreference, error) {
+	switch LangPreference(value) {
+	case LangAsLinked, LangPreferITA, LangPreferSubITA, LangBoth:
+		return LangPreference(value), nil
+	default:
+		return "", fmt.Errorf("unknown language %q, expected 'ita', 'sub-ita' or 'both'", value)
+	}
+}
+
+// Wants reports whether the version in lang, LangITA or LangSubITA,
+// should be downloaded
+func (p LangPreference) Wants(lang string) bool {
+	switch p {
+	case LangPreferITA:
+		return lang == LangITA
+	case LangPreferSubITA:
+		return lang == LangSubITA
+	default:
+		return true
+	}
+}
@@ -0,0 +1,136 @@
+package commons
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+type LogFormat string
+
+const (
+	LogText LogFormat = "text"
+	LogJSON LogFormat = "json"
+)
+
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q, expected 'debug', 'info', 'warn' or 'error'", value)
+	}
+}
+
+func parseLogFormat(value string) (LogFormat, error) {
+	switch LogFormat(value) {
+	case LogText, LogJSON:
+		return LogFormat(value), nil
+	default:
+		return "", fmt.Errorf("unknown log format %q, expected 'text' or 'json'", value)
+	}
+}
+
+// consoleOutput is where console logs are written. It's swapped for the
+// progress tracker while bars are on screen.
+var consoleOutput = &swappableWriter{w: os.Stderr}
+
+type swappableWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *swappableWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	w := s.w
+	s.mu.Unlock()
+	return w.Write(b)
+}
+
+// SetConsoleOutput redirects console logs to w, or back to stderr when w is nil.
+func SetConsoleOutput(w io.Writer) {
+	if w == nil {
+		w = os.Stderr
+	}
+	consoleOutput.mu.Lock()
+	defer consoleOutput.mu.Unlock()
+	consoleOutput.w = w
+}
+
+// setupLogger installs the default slog logger, writing to the console and
+// optionally to logFile. The returned file must be closed by the caller.
+func setupLogger(level slog.Level, format LogFormat, logFile string) (*os.File, error) {
+	handlers := multiHandler{newLogHandler(consoleOutput, format, level)}
+
+	var file *os.File
+	if logFile != "" {
+		var err error
+		file, err = os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open log file: %w", err)
+		}
+		handlers = append(handlers, newLogHandler(file, format, level))
+	}
+
+	slog.SetDefault(slog.New(handlers))
+	return file, nil
+}
+
+func newLogHandler(w io.Writer, format LogFormat, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == LogJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// multiHandler sends every record to all of its handlers
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, h := range m {
+		if !h.Enabled(ctx, record.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
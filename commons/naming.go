@@ -0,0 +1,291 @@
+package commons
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultOutputDir = "OtakuCrawler Downloads"
+	DefaultTemplate  = "{series}/{series}_Ep_{special}{episode:02}_{lang}.{ext}"
+)
+
+// Layout is a folder structure understood by a media server
+type Layout string
+
+const (
+	LayoutNone     Layout = ""
+	LayoutJellyfin Layout = "jellyfin"
+	LayoutPlex     Layout = "plex"
+	LayoutKodi     Layout = "kodi"
+)
+
+func parseLayout(value string) (Layout, error) {
+	switch Layout(value) {
+	case LayoutNone, LayoutJellyfin, LayoutPlex, LayoutKodi:
+		return Layout(value), nil
+	default:
+		return "", fmt.Errorf("unknown layout %q, expected 'jellyfin', 'plex' or 'kodi'", value)
+	}
+}
+
+// WritesNFO reports whether the media server reads .nfo metadata files.
+// Plex ignores them without a third-party agent.
+func (l Layout) WritesNFO() bool {
+	return l == LayoutJellyfin || l == LayoutKodi
+}
+
+// All the supported media servers expect the same naming
+const (
+	layoutSeriesFolder        = "{series} ({year})"
+	layoutSeriesFolderNoYear  = "{series}"
+	layoutEpisodePathTemplate = "/Season {season:02}/{series} - S{season:02}E{episode:02}.{ext}"
+)
+
+// EpisodeName holds the values a filename template can use
+type EpisodeName struct {
+	Series  string
+	Year    int     // 0 when unknown
+	Season  int     // 0 for specials
+	Episode float64 // 12.5 for half episodes, for specials their number among the specials
+	Special bool
+	Lang    string
+	Title   string
+	Ext     string
+}
+
+// FilenameTemplate builds the path of a downloaded episode, relative to the
+// output directory, e.g. "{series}/Season {season:02}/{series} - {episode:02}.{ext}"
+type FilenameTemplate struct {
+	raw   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   string // empty for literal text
+	width   int
+}
+
+var templateFields = []string{"series", "year", "season", "episode", "special", "lang", "title", "ext"}
+
+// specialMarker tells specials apart from the regular episode with the same
+// number in templates without a season
+const specialMarker = "SP"
+
+func ParseFilenameTemplate(raw string) (*FilenameTemplate, error) {
+	t, err := parseTemplate(raw)
+	if err != nil {
+		return nil, err
+	}
+	// Without the episode number every episode would land on the same file
+	if !t.Uses("episode") {
+		return nil, fmt.Errorf("template %q must contain {episode}", raw)
+	}
+	return t, nil
+}
+
+func parseTemplate(raw string) (*FilenameTemplate, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("template is empty")
+	}
+	if filepath.IsAbs(raw) || strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("template %q must be relative to the output directory", raw)
+	}
+
+	t := &FilenameTemplate{raw: raw}
+	rest := raw
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unclosed '{'", raw)
+		}
+
+		part, err := parseTemplateField(rest[open+1 : open+end])
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", raw, err)
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[open+end+1:]
+	}
+
+	for _, part := range t.parts {
+		if strings.Contains(part.literal, "}") {
+			return nil, fmt.Errorf("template %q: unexpected '}'", raw)
+		}
+		for _, segment := range strings.Split(part.literal, "/") {
+			if segment == ".." {
+				return nil, fmt.Errorf("template %q must stay inside the output directory", raw)
+			}
+		}
+	}
+	return t, nil
+}
+
+// parseTemplateField parses "name" or "name:width". The width only applies
+// to numbers, which are always padded with zeros: spaces would end up in
+// file names, so {episode:2} is the same as {episode:02}.
+func parseTemplateField(spec string) (templatePart, error) {
+	name, format, hasFormat := strings.Cut(spec, ":")
+	part := templatePart{field: name}
+
+	if !slices.Contains(templateFields, name) {
+		return part, fmt.Errorf("unknown placeholder {%s}, expected one of {%s}", spec, strings.Join(templateFields, "}, {"))
+	}
+
+	if hasFormat {
+		width, err := strconv.Atoi(format)
+		if err != nil || width < 0 {
+			return part, fmt.Errorf("invalid width in {%s}", spec)
+		}
+		part.width = width
+	}
+	return part, nil
+}
+
+// Uses reports whether the template contains the {field} placeholder
+func (t *FilenameTemplate) Uses(field string) bool {
+	return t != nil && slices.ContainsFunc(t.parts, func(part templatePart) bool { return part.field == field })
+}
+
+func (t *FilenameTemplate) String() string {
+	return t.raw
+}
+
+// Render returns the path of the episode relative to the output directory.
+// Values are sanitized so they can't add directories of their own.
+// Specials are numbered on their own, so in templates with neither {season}
+// nor {special} their episode number is marked as in "SP01".
+func (t *FilenameTemplate) Render(name EpisodeName) string {
+	markEpisode := name.Special && !t.Uses("season") && !t.Uses("special")
+	var sb strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			sb.WriteString(part.literal)
+			continue
+		}
+
+		var value string
+		switch part.field {
+		case "series":
+			value = name.Series
+		case "year":
+			value = formatNumber(name.Year, part)
+		case "season":
+			value = formatNumber(name.Season, part)
+		case "episode":
+			value = formatNumber(int(name.Episode), part) + episodeFraction(name.Episode)
+			if markEpisode {
+				value = specialMarker + value
+			}
+		case "special":
+			if name.Special {
+				value = specialMarker
+			}
+		case "lang":
+			value = name.Lang
+		case "title":
+			value = name.Title
+		case "ext":
+			value = name.Ext
+		}
+		sb.WriteString(sanitizePathValue(value))
+	}
+	return filepath.FromSlash(sb.String())
+}
+
+func formatNumber(n int, part templatePart) string {
+	return fmt.Sprintf("%0*d", part.width, n)
+}
+
+// FormatEpisode pads the whole part of an episode number to width digits,
+// keeping any fraction: 5 is "05" and 12.5 is "12.5"
+func FormatEpisode(n float64, width int) string {
+	return fmt.Sprintf("%0*d", width, int(n)) + episodeFraction(n)
+}
+
+func episodeFraction(n float64) string {
+	_, frac := math.Modf(n)
+	if frac == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(strconv.FormatFloat(frac, 'f', -1, 64), "0")
+}
+
+var unsafePathChars = regexp.MustCompile(`[<>:"/\\|?*]`)
+
+func sanitizePathValue(value string) string {
+	value = unsafePathChars.ReplaceAllString(value, "_")
+	if value == "." || value == ".." {
+		return "_"
+	}
+	return value
+}
+
+// EpisodePath returns where an episode is saved
+func (c DownloadConfig) EpisodePath(name EpisodeName) string {
+	template := c.Template
+	if c.Layout != LayoutNone {
+		template = layoutTemplate(layoutSeriesFolderOf(name) + layoutEpisodePathTemplate)
+	} else if template == nil {
+		template = layoutTemplate(DefaultTemplate)
+	}
+	return filepath.Join(c.outputDir(), template.Render(name))
+}
+
+// SeriesDir returns the series folder of a library layout, where the show
+// metadata and poster go. Empty without a layout.
+func (c DownloadConfig) SeriesDir(name EpisodeName) string {
+	if c.Layout == LayoutNone {
+		return ""
+	}
+	return filepath.Join(c.outputDir(), layoutTemplate(layoutSeriesFolderOf(name)).Render(name))
+}
+
+// SeparatesSeasons reports whether episodes of different seasons get
+// different paths, so seasons of a franchise can share the show name
+func (c DownloadConfig) SeparatesSeasons() bool {
+	return c.Layout != LayoutNone || c.Template.Uses("season")
+}
+
+// SeparatesLanguages reports whether the versions of a series in different
+// languages get different paths, layouts keep the dubbed one as its own show
+func (c DownloadConfig) SeparatesLanguages() bool {
+	return c.Layout != LayoutNone || c.Template == nil || c.Template.Uses("lang")
+}
+
+func (c DownloadConfig) outputDir() string {
+	if c.OutputDir == "" {
+		return DefaultOutputDir
+	}
+	return c.OutputDir
+}
+
+func layoutSeriesFolderOf(name EpisodeName) string {
+	if name.Year > 0 {
+		return layoutSeriesFolder
+	}
+	return layoutSeriesFolderNoYear
+}
+
+// layoutTemplate parses a built-in template, which can't fail
+func layoutTemplate(raw string) *FilenameTemplate {
+	template, err := parseTemplate(raw)
+	if err != nil {
+		panic(err)
+	}
+	return template
+}
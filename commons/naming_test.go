@@ -0,0 +1,57 @@
+package commons
+
+import "testing"
+
+// Specials are numbered among themselves, so OVA 1 must not land on the
+// file of episode 1
+func TestRenderSpecialsApart(t *testing.T) {
+	tests := []struct {
+		template string
+		regular  string
+		special  string
+	}{
+		{DefaultTemplate, "Naruto/Naruto_Ep_01_SUB_ITA.mp4", "Naruto/Naruto_Ep_SP01_SUB_ITA.mp4"},
+		{"{series} - {episode:02}.{ext}", "Naruto - 01.mp4", "Naruto - SP01.mp4"},
+		{"{series} {special}{episode}.{ext}", "Naruto 1.mp4", "Naruto SP1.mp4"},
+		{"S{season:02}E{episode:02}.{ext}", "S01E01.mp4", "S00E01.mp4"},
+	}
+	for _, tt := range tests {
+		template, err := ParseFilenameTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("ParseFilenameTemplate(%q): %v", tt.template, err)
+		}
+		regular := EpisodeName{Series: "Naruto", Season: 1, Episode: 1, Lang: LangSubITA, Ext: "mp4"}
+		special := EpisodeName{Series: "Naruto", Season: 0, Episode: 1, Special: true, Lang: LangSubITA, Ext: "mp4"}
+
+		gotRegular, gotSpecial := template.Render(regular), template.Render(special)
+		if gotRegular == gotSpecial {
+			t.Errorf("%q: episode 1 and special 1 both render to %q", tt.template, gotRegular)
+		}
+		if gotRegular != tt.regular {
+			t.Errorf("%q: episode 1 = %q, want %q", tt.template, gotRegular, tt.regular)
+		}
+		if gotSpecial != tt.special {
+			t.Errorf("%q: special 1 = %q, want %q", tt.template, gotSpecial, tt.special)
+		}
+	}
+}
+
+// Widths always pad with zeros, spaces don't belong in file names
+func TestRenderWidth(t *testing.T) {
+	tests := map[string]string{
+		"{episode:2}":            "05",
+		"{episode:03}":           "005",
+		"{episode}":              "5",
+		"{season:2}x{episode:2}": "01x05",
+	}
+	for text, want := range tests {
+		template, err := ParseFilenameTemplate(text + ".{ext}")
+		if err != nil {
+			t.Fatalf("ParseFilenameTemplate(%q): %v", text, err)
+		}
+		got := template.Render(EpisodeName{Series: "Naruto", Season: 1, Episode: 5, Ext: "mp4"})
+		if got != want+".mp4" {
+			t.Errorf("%q renders %q, want %q", text, got, want+".mp4")
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package commons
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type OutputFormat string
+
+const (
+	OutputText OutputFormat = "text"
+	OutputJSON OutputFormat = "json"
+)
+
+func parseOutputFormat(value string) (OutputFormat, error) {
+	switch OutputFormat(value) {
+	case OutputText, OutputJSON:
+		return OutputFormat(value), nil
+	default:
+		return "", fmt.Errorf("unknown output format %q, expected 'text' or 'json'", value)
+	}
+}
+
+// ExportFormat is a file format understood by external downloaders
+type ExportFormat string
+
+const (
+	ExportNone     ExportFormat = ""
+	ExportAria2    ExportFormat = "aria2"
+	ExportURLs     ExportFormat = "urls"
+	ExportM3U      ExportFormat = "m3u"
+	ExportCrawljob ExportFormat = "crawljob"
+)
+
+func parseExportFormat(value string) (ExportFormat, error) {
+	switch ExportFormat(value) {
+	case ExportAria2, ExportURLs, ExportM3U, ExportCrawljob:
+		return ExportFormat(value), nil
+	default:
+		return "", fmt.Errorf("unknown export format %q, expected 'aria2', 'urls', 'm3u' or 'crawljob'", value)
+	}
+}
+
+// Event types emitted while downloading
+const (
+	EventEpisodesFound     = "episodes_found"
+	EventEpisodeResolved   = "episode_resolved"
+	EventEpisodeFailed     = "episode_failed"
+	EventDownloadStarted   = "download_started"
+	EventDownloadSkipped   = "download_skipped"
+	EventDownloadCompleted = "download_completed"
+	EventDownloadFailed    = "download_failed"
+	EventFinished          = "finished"
+)
+
+// Event is a single line of the NDJSON stream written during downloads.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Episode   string    `json:"episode,omitempty"` // label as shown by the site, e.g. "12.5" or "OVA"
+	Count     int       `json:"count,omitempty"`
+	StreamURL string    `json:"stream_url,omitempty"`
+	HLS       bool      `json:"hls,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// EventWriter writes events as newline-delimited JSON. A nil *EventWriter
+// discards everything, so callers don't need to check the output format.
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *EventWriter) Emit(event Event) {
+	if w == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.enc.Encode(event)
+}
@@ -0,0 +1,421 @@
+package commons
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	progressBarWidth      = 30
+	progressTTYInterval   = 200 * time.Millisecond
+	progressPlainInterval = 10 * time.Second
+	speedSmoothing        = 0.3 // weight of the newest sample in the speed average
+)
+
+// Progress tracks concurrent downloads and renders them as live multi-line
+// bars when the output is a terminal, or as periodic plain lines otherwise.
+// It also implements io.Writer so log output can be routed through it
+// without tearing the bars apart.
+type Progress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	isTTY    bool
+	interval time.Duration
+
+	tasks []*ProgressTask // active tasks, in the order they were added
+	drawn int             // number of bar lines currently on screen
+
+	totalEpisodes  int
+	doneEpisodes   int
+	failedEpisodes int
+	doneBytes      int64 // bytes of tasks that are no longer active
+
+	startTime time.Time
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// ProgressTask is the progress of a single episode download.
+type ProgressTask struct {
+	p    *Progress
+	name string
+
+	status        string
+	bytes         int64
+	totalBytes    int64 // 0 when unknown
+	segments      int
+	totalSegments int // 0 for non-segmented downloads
+
+	startTime time.Time
+	lastBytes int64
+	lastTick  time.Time
+	speed     float64 // bytes per second, smoothed
+}
+
+// NewProgress creates a progress tracker writing to out. Bars are only
+// drawn when out is a terminal.
+func NewProgress(out *os.File) *Progress {
+	p := &Progress{
+		out:      out,
+		isTTY:    isTerminal(out),
+		interval: progressPlainInterval,
+	}
+	if p.isTTY {
+		p.interval = progressTTYInterval
+	}
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start begins periodic rendering until Stop is called.
+func (p *Progress) Start() {
+	p.mu.Lock()
+	p.startTime = time.Now()
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	p.mu.Unlock()
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				p.render()
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop stops rendering, clears the bars and prints a final summary line.
+func (p *Progress) Stop() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	elapsed := time.Since(p.startTime)
+	fmt.Fprintf(p.out, "Finished %d/%d episodes (%d failed), %s in %s\n",
+		p.doneEpisodes, p.totalEpisodes, p.failedEpisodes,
+		formatBytes(p.doneBytes), elapsed.Round(time.Second))
+}
+
+// SetTotalEpisodes sets the number of episodes expected in this run, used
+// for the overall line.
+func (p *Progress) SetTotalEpisodes(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.totalEpisodes = n
+}
+
+// AddTask registers a new active download.
+func (p *Progress) AddTask(name string) *ProgressTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	task := &ProgressTask{
+		p:         p,
+		name:      name,
+		status:    "starting",
+		startTime: now,
+		lastTick:  now,
+	}
+	p.tasks = append(p.tasks, task)
+	return task
+}
+
+// Fail counts an episode that failed before its download could start,
+// e.g. because its stream couldn't be found
+func (p *Progress) Fail() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failedEpisodes++
+	if p.isTTY {
+		p.clear()
+		p.draw()
+	}
+}
+
+// Write prints log output above the bars and redraws them underneath.
+func (p *Progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.out.Write(b)
+	if p.isTTY {
+		p.draw()
+	}
+	return n, err
+}
+
+// clear removes the bars from the screen. Must be called with mu held.
+func (p *Progress) clear() {
+	if !p.isTTY || p.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(p.out, "\x1b[%dA\r\x1b[J", p.drawn)
+	p.drawn = 0
+}
+
+// render updates speeds and outputs the current state. Must be called with mu held.
+func (p *Progress) render() {
+	now := time.Now()
+	for _, t := range p.tasks {
+		t.sample(now)
+	}
+
+	if p.isTTY {
+		p.clear()
+		p.draw()
+		return
+	}
+
+	for _, t := range p.tasks {
+		fmt.Fprintln(p.out, t.line(false))
+	}
+	if len(p.tasks) > 0 {
+		fmt.Fprintln(p.out, p.overallLine())
+	}
+}
+
+// draw writes the bars. Must be called with mu held and the screen cleared.
+func (p *Progress) draw() {
+	for _, t := range p.tasks {
+		fmt.Fprintln(p.out, t.line(true))
+	}
+	fmt.Fprintln(p.out, p.overallLine())
+	p.drawn = len(p.tasks) + 1
+}
+
+func (p *Progress) overallLine() string {
+	var bytes, total int64
+	var speed float64
+	for _, t := range p.tasks {
+		bytes += t.bytes
+		total += t.estimatedTotal()
+		speed += t.speed
+	}
+
+	line := fmt.Sprintf("Overall: %d/%d episodes, %s downloaded, %s/s",
+		p.doneEpisodes, p.totalEpisodes, formatBytes(p.doneBytes+bytes), formatBytes(int64(speed)))
+
+	// Episodes that haven't started yet are assumed to be as big as the
+	// average of the ones we know about, failed ones won't be downloaded
+	known := p.doneEpisodes + len(p.tasks)
+	pending := p.totalEpisodes - known - p.failedEpisodes
+	if speed > 0 && known > 0 && p.totalEpisodes > 0 {
+		perEpisode := float64(p.doneBytes+total) / float64(known)
+		remaining := float64(total-bytes) + perEpisode*float64(max(pending, 0))
+		if remaining > 0 {
+			line += ", ETA " + formatETA(remaining/speed)
+		}
+	}
+	return line
+}
+
+// SetStatus sets a short description of what the task is currently doing.
+func (t *ProgressTask) SetStatus(status string) {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.status = status
+}
+
+// SetTotalBytes sets the expected size of the download, if known.
+func (t *ProgressTask) SetTotalBytes(n int64) {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.totalBytes = n
+	t.status = "downloading"
+}
+
+// SetTotalSegments sets the number of segments of an HLS download.
+func (t *ProgressTask) SetTotalSegments(n int) {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.totalSegments = n
+	t.status = "downloading"
+}
+
+// AddBytes records n more downloaded bytes.
+func (t *ProgressTask) AddBytes(n int64) {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.bytes += n
+}
+
+// Resume records n bytes already on disk from an earlier run. They don't
+// count towards the speed.
+func (t *ProgressTask) Resume(n int64) {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.bytes += n
+	t.lastBytes += n
+}
+
+// SegmentDone records one more completed segment.
+func (t *ProgressTask) SegmentDone() {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.segments++
+}
+
+// Reader wraps r so that everything read from it is counted by the task.
+func (t *ProgressTask) Reader(r io.Reader) io.Reader {
+	return &progressReader{reader: r, task: t}
+}
+
+// Done removes the task from the active list. A nil err counts the episode
+// as completed, anything else as failed.
+func (t *ProgressTask) Done(err error) {
+	p := t.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, active := range p.tasks {
+		if active == t {
+			p.tasks = append(p.tasks[:i], p.tasks[i+1:]...)
+			break
+		}
+	}
+	p.doneBytes += t.bytes
+	if err != nil {
+		p.failedEpisodes++
+	} else {
+		p.doneEpisodes++
+	}
+	if p.isTTY {
+		p.clear()
+		p.draw()
+	}
+}
+
+// sample updates the smoothed speed. Must be called with mu held.
+func (t *ProgressTask) sample(now time.Time) {
+	dt := now.Sub(t.lastTick).Seconds()
+	if dt <= 0 {
+		return
+	}
+	current := float64(t.bytes-t.lastBytes) / dt
+	if t.speed == 0 {
+		t.speed = current
+	} else {
+		t.speed = speedSmoothing*current + (1-speedSmoothing)*t.speed
+	}
+	t.lastBytes = t.bytes
+	t.lastTick = now
+}
+
+// estimatedTotal returns the expected size in bytes. HLS playlists don't
+// carry sizes, so it's extrapolated from the segments downloaded so far.
+func (t *ProgressTask) estimatedTotal() int64 {
+	if t.totalBytes > 0 {
+		return t.totalBytes
+	}
+	if t.totalSegments > 0 && t.segments > 0 {
+		return t.bytes * int64(t.totalSegments) / int64(t.segments)
+	}
+	return t.bytes
+}
+
+func (t *ProgressTask) fraction() float64 {
+	if t.totalSegments > 0 {
+		return float64(t.segments) / float64(t.totalSegments)
+	}
+	if t.totalBytes > 0 {
+		return float64(t.bytes) / float64(t.totalBytes)
+	}
+	return 0
+}
+
+func (t *ProgressTask) line(withBar bool) string {
+	fraction := t.fraction()
+	var sb strings.Builder
+	sb.WriteString(t.name)
+	sb.WriteString(" ")
+
+	if withBar {
+		filled := int(fraction * progressBarWidth)
+		if filled > progressBarWidth {
+			filled = progressBarWidth
+		}
+		sb.WriteString("[")
+		sb.WriteString(strings.Repeat("=", filled))
+		sb.WriteString(strings.Repeat(" ", progressBarWidth-filled))
+		sb.WriteString("] ")
+	}
+
+	fmt.Fprintf(&sb, "%5.1f%% ", fraction*100)
+	if t.totalSegments > 0 {
+		fmt.Fprintf(&sb, "%d/%d segments, %s", t.segments, t.totalSegments, formatBytes(t.bytes))
+	} else if t.totalBytes > 0 {
+		fmt.Fprintf(&sb, "%s/%s", formatBytes(t.bytes), formatBytes(t.totalBytes))
+	} else {
+		sb.WriteString(formatBytes(t.bytes))
+	}
+	fmt.Fprintf(&sb, " %s/s", formatBytes(int64(t.speed)))
+
+	if remaining := t.estimatedTotal() - t.bytes; t.speed > 0 && remaining > 0 {
+		sb.WriteString(" ETA ")
+		sb.WriteString(formatETA(float64(remaining) / t.speed))
+	}
+	if t.status != "downloading" {
+		fmt.Fprintf(&sb, " (%s)", t.status)
+	}
+	return sb.String()
+}
+
+type progressReader struct {
+	reader io.Reader
+	task   *ProgressTask
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	if n > 0 {
+		r.task.AddBytes(int64(n))
+	}
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	suffixes := []string{"KB", "MB", "GB", "TB"}
+	i := -1
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
+}
+
+func formatETA(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Second)
+	if d > 99*time.Hour {
+		return "--"
+	}
+	return d.String()
+}
@@ -0,0 +1,245 @@
+package commons
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EpisodeRef is what a selector needs to know about an episode
+type EpisodeRef struct {
+	Label      string // as shown by the site, e.g. "12.5" or "OVA"
+	Number     float64
+	Special    bool
+	Downloaded bool // already on disk, only needed by "new"
+}
+
+type termKind int
+
+const (
+	termLabel termKind = iota
+	termRange
+	termLatest
+	termNew
+	termAll
+	termSpecials
+)
+
+type selectorTerm struct {
+	kind    termKind
+	exclude bool
+	label   string  // termLabel
+	from    float64 // termRange
+	to      float64 // termRange, ignored when openEnd
+	openEnd bool
+	count   int // termLatest
+}
+
+// EpisodeSelector chooses episodes with expressions like "1-5,8,10-",
+// "latest:3", "!7" or "new". Terms are joined, "!" terms are then removed.
+// A selector without positive terms starts from every episode.
+type EpisodeSelector struct {
+	raw   string
+	terms []selectorTerm
+}
+
+// ParseEpisodeSelector parses a selector, an empty one selects everything
+func ParseEpisodeSelector(raw string) (*EpisodeSelector, error) {
+	s := &EpisodeSelector{raw: strings.TrimSpace(raw)}
+	if s.raw == "" {
+		return s, nil
+	}
+
+	for _, text := range strings.Split(s.raw, ",") {
+		term, err := parseSelectorTerm(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid episode selection %q: %w", s.raw, err)
+		}
+		s.terms = append(s.terms, term)
+	}
+	return s, nil
+}
+
+// JoinEpisodeSelections combines selections, an episode chosen by any of
+// them is selected
+func JoinEpisodeSelections(selections ...string) string {
+	var parts []string
+	for _, selection := range selections {
+		if selection = strings.TrimSpace(selection); selection != "" {
+			parts = append(parts, selection)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseSelectorTerm(text string) (selectorTerm, error) {
+	var term selectorTerm
+	if rest, ok := strings.CutPrefix(text, "!"); ok {
+		term.exclude = true
+		text = strings.TrimSpace(rest)
+	}
+	if text == "" {
+		return term, fmt.Errorf("empty term")
+	}
+
+	lower := strings.ToLower(text)
+	switch {
+	case lower == "new":
+		term.kind = termNew
+	case lower == "all" || lower == "*":
+		term.kind = termAll
+	case lower == "specials":
+		term.kind = termSpecials
+	case strings.HasPrefix(lower, "latest:"):
+		count, err := strconv.Atoi(strings.TrimSpace(text[len("latest:"):]))
+		if err != nil || count < 1 {
+			return term, fmt.Errorf("%q needs a positive count, like latest:3", text)
+		}
+		term.kind = termLatest
+		term.count = count
+	case isRange(text):
+		from, to, _ := strings.Cut(text, "-")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		term.kind = termRange
+		if from != "" {
+			term.from, _ = strconv.ParseFloat(from, 64)
+		}
+		if to == "" {
+			term.openEnd = true
+			break
+		}
+		term.to, _ = strconv.ParseFloat(to, 64)
+		if term.to < term.from {
+			return term, fmt.Errorf("range %q ends before it starts", text)
+		}
+	case strings.Contains(text, ":"):
+		return term, fmt.Errorf("unknown term %q, expected a number, a range, latest:N, new or a label", text)
+	default:
+		term.kind = termLabel
+		term.label = text
+		if n, err := strconv.ParseFloat(text, 64); err == nil {
+			term.label = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+	}
+	return term, nil
+}
+
+// isRange reports whether text is a range of episode numbers, like "3-5",
+// "10-" or "-4". Other terms with a dash, like "OVA-1", are labels.
+func isRange(text string) bool {
+	from, to, ok := strings.Cut(text, "-")
+	if !ok {
+		return false
+	}
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" && to == "" {
+		return false
+	}
+	for _, bound := range []string{from, to} {
+		if bound == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(bound, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *EpisodeSelector) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.raw
+}
+
+// NeedsDownloadState reports whether Select looks at EpisodeRef.Downloaded
+func (s *EpisodeSelector) NeedsDownloadState() bool {
+	return s != nil && slices.ContainsFunc(s.terms, func(term selectorTerm) bool { return term.kind == termNew })
+}
+
+// Select returns the indices of the selected episodes in list order, and
+// the labels that were asked for but don't exist
+func (s *EpisodeSelector) Select(episodes []EpisodeRef) ([]int, []string) {
+	selected := make([]bool, len(episodes))
+	var missing []string
+
+	hasIncludes := s != nil && slices.ContainsFunc(s.terms, func(term selectorTerm) bool { return !term.exclude })
+	if !hasIncludes {
+		for i := range selected {
+			selected[i] = true
+		}
+	}
+
+	if s != nil {
+		// Includes first, so the order of the terms doesn't matter
+		for _, exclude := range []bool{false, true} {
+			for _, term := range s.terms {
+				if term.exclude != exclude {
+					continue
+				}
+				matches := term.matches(episodes)
+				if term.kind == termLabel && !slices.Contains(matches, true) && !term.exclude {
+					missing = append(missing, term.label)
+				}
+				for i, match := range matches {
+					if match {
+						selected[i] = !exclude
+					}
+				}
+			}
+		}
+	}
+
+	var indices []int
+	for i, ok := range selected {
+		if ok {
+			indices = append(indices, i)
+		}
+	}
+	return indices, missing
+}
+
+// matches returns which episodes the term matches. Ranges and latest only
+// cover regular episodes, specials are picked by label or "specials".
+func (t selectorTerm) matches(episodes []EpisodeRef) []bool {
+	matches := make([]bool, len(episodes))
+	switch t.kind {
+	case termLabel:
+		for i, episode := range episodes {
+			matches[i] = strings.EqualFold(episode.Label, t.label)
+		}
+	case termRange:
+		for i, episode := range episodes {
+			matches[i] = !episode.Special && episode.Number >= t.from && (t.openEnd || episode.Number <= t.to)
+		}
+	case termLatest:
+		var regular []int
+		for i, episode := range episodes {
+			if !episode.Special {
+				regular = append(regular, i)
+			}
+		}
+		slices.SortStableFunc(regular, func(a, b int) int {
+			return cmp.Compare(episodes[a].Number, episodes[b].Number)
+		})
+		for _, i := range regular[max(0, len(regular)-t.count):] {
+			matches[i] = true
+		}
+	case termNew:
+		for i, episode := range episodes {
+			matches[i] = !episode.Downloaded
+		}
+	case termAll:
+		for i := range matches {
+			matches[i] = true
+		}
+	case termSpecials:
+		for i, episode := range episodes {
+			matches[i] = episode.Special
+		}
+	}
+	return matches
+}
@@ -0,0 +1,98 @@
+package commons
+
+import (
+	"slices"
+	"testing"
+)
+
+// testEpisodes has a half episode, two specials and episodes 1, 2 and 4 on
+// disk
+var testEpisodes = []EpisodeRef{
+	{Label: "1", Number: 1, Downloaded: true},
+	{Label: "2", Number: 2, Downloaded: true},
+	{Label: "3", Number: 3},
+	{Label: "4", Number: 4, Downloaded: true},
+	{Label: "4.5", Number: 4.5},
+	{Label: "OVA-1", Number: 1, Special: true},
+	{Label: "5", Number: 5},
+	{Label: "Special", Number: 2, Special: true},
+	{Label: "6", Number: 6},
+}
+
+func TestEpisodeSelector(t *testing.T) {
+	tests := []struct {
+		selection string
+		want      []string
+		missing   []string
+	}{
+		{"", []string{"1", "2", "3", "4", "4.5", "OVA-1", "5", "Special", "6"}, nil},
+		{"3", []string{"3"}, nil},
+		{"04.50", []string{"4.5"}, nil},
+		{"2-4", []string{"2", "3", "4"}, nil},
+		{"4 - 5", []string{"4", "4.5", "5"}, nil},
+		{"5-", []string{"5", "6"}, nil},
+		{"-2", []string{"1", "2"}, nil},
+		{"latest:2", []string{"5", "6"}, nil},
+		{"LATEST:20", []string{"1", "2", "3", "4", "4.5", "5", "6"}, nil},
+		{"new", []string{"3", "4.5", "OVA-1", "5", "Special", "6"}, nil},
+		{"all", []string{"1", "2", "3", "4", "4.5", "OVA-1", "5", "Special", "6"}, nil},
+		{"*", []string{"1", "2", "3", "4", "4.5", "OVA-1", "5", "Special", "6"}, nil},
+		{"specials", []string{"OVA-1", "Special"}, nil},
+		{"ova-1", []string{"OVA-1"}, nil},
+		{"OVA-1,1", []string{"1", "OVA-1"}, nil},
+		{"1,9,OVA-2", []string{"1"}, []string{"9", "OVA-2"}},
+		{"!3", []string{"1", "2", "4", "4.5", "OVA-1", "5", "Special", "6"}, nil},
+		{"!2-5,!specials", []string{"1", "6"}, nil},
+		{"1-4,!4.5,!2", []string{"1", "3", "4"}, nil},
+		{"!1,1-2", []string{"2"}, nil},
+		{"new,!specials", []string{"3", "4.5", "5", "6"}, nil},
+		{"all,!OVA-1", []string{"1", "2", "3", "4", "4.5", "5", "Special", "6"}, nil},
+		{"latest:3,!latest:1", []string{"4.5", "5"}, nil},
+	}
+	for _, tt := range tests {
+		selector, err := ParseEpisodeSelector(tt.selection)
+		if err != nil {
+			t.Errorf("ParseEpisodeSelector(%q): %v", tt.selection, err)
+			continue
+		}
+		indices, missing := selector.Select(testEpisodes)
+		var got []string
+		for _, i := range indices {
+			got = append(got, testEpisodes[i].Label)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q selects %q, want %q", tt.selection, got, tt.want)
+		}
+		if !slices.Equal(missing, tt.missing) {
+			t.Errorf("%q reports %q missing, want %q", tt.selection, missing, tt.missing)
+		}
+	}
+}
+
+func TestEpisodeSelectorInvalid(t *testing.T) {
+	for _, selection := range []string{
+		"5-3",
+		"1,,2",
+		"!",
+		"latest:",
+		"latest:0",
+		"latest:x",
+		"first:2",
+	} {
+		if _, err := ParseEpisodeSelector(selection); err == nil {
+			t.Errorf("ParseEpisodeSelector(%q) should fail", selection)
+		}
+	}
+}
+
+func TestNeedsDownloadState(t *testing.T) {
+	for selection, want := range map[string]bool{"": false, "1-3": false, "new": true, "1,!new": true} {
+		selector, err := ParseEpisodeSelector(selection)
+		if err != nil {
+			t.Fatalf("ParseEpisodeSelector(%q): %v", selection, err)
+		}
+		if got := selector.NeedsDownloadState(); got != want {
+			t.Errorf("%q: NeedsDownloadState() = %v, want %v", selection, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package commons
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SignalContext returns a context cancelled on the first SIGINT/SIGTERM, so
+// workers can stop cleanly. A second signal exits immediately.
+func SignalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("Shutting down, press Ctrl-C again to quit immediately", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+			return
+		}
+
+		<-signals
+		slog.Error("Forced quit, partial downloads are kept as .part files")
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -1,53 +1,452 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"otakucrawler/commons"
 	"otakucrawler/scrapers"
-	"time"
+	"strconv"
+	"strings"
 )
 
 func main() {
-	printBanner()
+	os.Exit(run())
+}
 
-	setupResult := commons.CommonSetup()
+func run() int {
+	setupResult, err := commons.ParseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintln(os.Stderr, "Run 'otakucrawler help' for usage.")
+		return 2
+	}
 	if setupResult.Action == commons.Exit {
-		return
+		return 0
+	}
+	if setupResult.LogFile != nil {
+		defer setupResult.LogFile.Close()
 	}
 
-	scraper := scrapers.GetScraper(setupResult.URL)
-	if scraper == nil {
-		log.Printf("Scraper not available for %s", setupResult.URL)
-		return
+	ctx, stop := commons.SignalContext()
+	defer stop()
+
+	if setupResult.Action == commons.Doctor {
+		printBanner()
+		if err := commons.RunDoctor(ctx, os.Stdout, setupResult.BrowserOptions, checkSites); err != nil {
+			slog.Error("Doctor found problems", "error", err)
+			return 1
+		}
+		return 0
+	}
+
+	// Site definitions are only read by the commands that scrape, links are
+	// checked against their hosts. A broken one stops here rather than on
+	// every page, and like other usage errors before the banner.
+	siteFiles, err := scrapers.LoadSites(commons.SitesDir())
+	if err != nil {
+		slog.Error("Could not load site definitions", "error", err)
+		return 1
+	}
+	if err := commons.CheckSupportedSites(setupResult); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintln(os.Stderr, "Run 'otakucrawler help' for usage.")
+		return 2
+	}
+
+	printBanner()
+	for _, file := range siteFiles {
+		slog.Info("Using site definition", "file", file)
+	}
+
+	setupResult, err = commons.CommonSetup(setupResult)
+	// Browser and Playwright are closed on the way out even when interrupted,
+	// otherwise Firefox processes are left behind
+	defer closeBrowser(setupResult)
+	if err != nil {
+		slog.Error("Setup failed", "error", err)
+		return 1
 	}
 
-	linksChannel := make(chan []string)
+	// Pages come over plain HTTP, the browser only starts for the ones
+	// that need it
+	fetcher := scrapers.NewFetcher(setupResult.Browser, setupResult.Fetch)
+
 	switch setupResult.Action {
 	case commons.Download:
-		scraper.Download(
-			setupResult.Page,
-			setupResult.Browser,
-			setupResult.EpisodeRange,
-			setupResult.SpecificEpisodes,
+		err = downloadSeries(ctx, fetcher, setupResult, setupResult.URL, setupResult.Episodes)
+		if err != nil {
+			slog.Error("Download finished with errors", "error", err)
+		}
+	case commons.Search:
+		err = search(ctx, fetcher, setupResult)
+	case commons.List:
+		err = listEpisodes(ctx, fetcher, setupResult)
+	case commons.Sync:
+		err = syncWatchlist(ctx, fetcher, setupResult)
+	case commons.Serve:
+		err = serve(ctx, fetcher, setupResult)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// checkSites is the doctor check of the site definitions
+func checkSites(ctx context.Context) (string, error) {
+	files, err := scrapers.LoadSites(commons.SitesDir())
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "built-in", nil
+	}
+	return "built-in and " + strings.Join(files, ", "), nil
+}
+
+// scraperFor returns the scraper of the site url belongs to
+func scraperFor(url string) (scrapers.Scraper, error) {
+	scraper := scrapers.GetScraper(url)
+	if scraper == nil {
+		return nil, fmt.Errorf("scraper not available for %s", url)
+	}
+	return scraper, nil
+}
+
+// downloadSeries downloads a series, with its related seasons when asked
+// for with --all-seasons
+func downloadSeries(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult, url string, episodes *commons.EpisodeSelector) error {
+	if !setupResult.AllSeasons {
+		return downloadVersions(ctx, fetcher, setupResult, url, episodes)
+	}
+
+	scraper, err := scraperFor(url)
+	if err != nil {
+		return err
+	}
+	seasons, err := scraper.Seasons(ctx, fetcher, url)
+	if err != nil {
+		return fmt.Errorf("could not find the related seasons: %w", err)
+	}
+
+	// Without season numbers in the paths every season keeps its own name,
+	// otherwise they would overwrite each other
+	shareShow := setupResult.DownloadConfig.SeparatesSeasons()
+	if !shareShow {
+		slog.Info("Seasons are saved as separate series, use --layout or {season} in --template to group them")
+	}
+
+	var errs []error
+	specials := 0
+	for i, season := range seasons {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Info("Downloading season", "season", i+1, "of", len(seasons), "title", season.Title, "year", season.Year, "url", season.URL)
+
+		seasonSetup := setupResult
+		if shareShow {
+			seasonSetup.DownloadConfig.Franchise = &commons.FranchiseSeason{
+				Series:         seasons[0].Title,
+				Year:           seasons[0].Year,
+				Season:         i + 1,
+				SpecialsBefore: specials,
+			}
+			// The specials of the next seasons come after these ones
+			if i < len(seasons)-1 {
+				specials += countSpecials(ctx, fetcher, scraper, season.URL)
+			}
+		}
+		if err := downloadVersions(ctx, fetcher, seasonSetup, season.URL, episodes); err != nil {
+			errs = append(errs, fmt.Errorf("season %d (%s): %w", i+1, season.Title, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// countSpecials returns how many specials the series page lists
+func countSpecials(ctx context.Context, fetcher *scrapers.Fetcher, scraper scrapers.Scraper, url string) int {
+	links, err := scraper.ListEpisodes(ctx, fetcher, url)
+	if err != nil {
+		slog.Warn("Could not count the specials of the season, the next ones may be numbered like them", "url", url, "error", err)
+		return 0
+	}
+	count := 0
+	for _, link := range links {
+		if link.Special {
+			count++
+		}
+	}
+	return count
+}
+
+// downloadVersions downloads a series page, in the versions asked for with
+// --lang
+func downloadVersions(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult, url string, episodes *commons.EpisodeSelector) error {
+	scraper, err := scraperFor(url)
+	if err != nil {
+		return err
+	}
+	if setupResult.Lang == commons.LangAsLinked {
+		return scraper.Download(
+			ctx,
+			fetcher,
+			url,
+			episodes,
 			setupResult.DownloadConfig,
 			setupResult.FFmpegPath,
 		)
-	case commons.Search:
-		linksChannel <- scraper.GetLinks(setupResult.Page, setupResult.Browser)
-		time.Sleep(1 * time.Second)
-		log.Printf("DLC Links: %v", <-linksChannel)
 	}
 
-	if setupResult.Browser != nil {
-		if err := setupResult.Browser.Close(); err != nil {
-			log.Fatalf("could not close browser: %v", err)
+	versions, err := pickVersions(ctx, fetcher, setupResult, scraper, url)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, version := range versions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Info("Downloading version", "lang", version.Lang, "url", version.URL)
+		err := scraper.Download(
+			ctx,
+			fetcher,
+			version.URL,
+			episodes,
+			setupResult.DownloadConfig,
+			setupResult.FFmpegPath,
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s version: %w", version.Lang, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pickVersions returns the language versions of the series wanted by
+// --lang. When the preferred one doesn't exist the linked one is used.
+func pickVersions(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult, scraper scrapers.Scraper, url string) ([]scrapers.SeriesResult, error) {
+	variants, err := scraper.Variants(ctx, fetcher, url)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Without the linked series there is nothing to fall back to
+		if len(variants) == 0 {
+			return nil, err
+		}
+		slog.Warn("Could not find other language versions, downloading the linked one", "lang", variants[0].Lang, "url", url, "error", err)
+		return variants[:1], nil
+	}
+
+	var versions []scrapers.SeriesResult
+	for _, variant := range variants {
+		if setupResult.Lang.Wants(variant.Lang) {
+			versions = append(versions, variant)
+		}
+	}
+	switch {
+	case len(versions) == 0:
+		slog.Warn("No version in the requested language, downloading the linked one", "lang", setupResult.Lang, "url", url)
+		return variants[:1], nil
+	case setupResult.Lang == commons.LangBoth && len(versions) == 1:
+		slog.Warn("Only one language version found", "lang", versions[0].Lang, "url", url)
+	}
+	return versions, nil
+}
+
+func search(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult) error {
+	if setupResult.Query != "" {
+		return searchTitle(ctx, fetcher, setupResult)
+	}
+
+	scraper, err := scraperFor(setupResult.URL)
+	if err != nil {
+		slog.Error("Search failed", "error", err)
+		return err
+	}
+
+	links, err := scraper.GetLinks(ctx, fetcher, setupResult.URL, setupResult.Resolve)
+	if setupResult.ExportFormat == commons.ExportNone || setupResult.ExportFile != "" {
+		printLinks(setupResult.Output, setupResult.URL, links, err)
+	}
+	if len(links) > 0 && setupResult.ExportFormat != commons.ExportNone {
+		if err := exportLinks(setupResult.ExportFormat, setupResult.ExportFile, links); err != nil {
+			slog.Error("Export failed", "error", err)
+			return err
+		}
+	}
+	return err
+}
+
+// searchTitle looks a series up by title and downloads the one picked with
+// --pick, or asked for when running in a terminal
+func searchTitle(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult) error {
+	scraper := scrapers.GetScraper(setupResult.Site)
+	if scraper == nil {
+		err := fmt.Errorf("scraper not available for %s", setupResult.Site)
+		slog.Error("Search failed", "error", err)
+		return err
+	}
+
+	results, err := scraper.FindSeries(ctx, fetcher, setupResult.Site, setupResult.Query)
+	if err != nil {
+		slog.Error("Search failed", "error", err)
+		return err
+	}
+	if len(results) == 0 {
+		slog.Warn("No series found", "title", setupResult.Query)
+		return nil
+	}
+
+	pick := setupResult.Pick
+	switch {
+	case setupResult.Output == commons.OutputJSON && pick == 0:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case pick == 0:
+		printSeries(results)
+		if !setupResult.Interactive {
+			return nil
 		}
+		if pick, err = askPick(len(results)); err != nil || pick == 0 {
+			return err
+		}
+	case pick > len(results):
+		err := fmt.Errorf("--pick %d but only %d series were found", pick, len(results))
+		slog.Error("Search failed", "error", err)
+		return err
+	}
+
+	picked := results[pick-1]
+	slog.Info("Picked series", "title", picked.Title, "lang", picked.Lang, "url", picked.URL)
+	if err := downloadSeries(ctx, fetcher, setupResult, picked.URL, setupResult.Episodes); err != nil {
+		slog.Error("Download finished with errors", "error", err)
+		return err
 	}
+	return nil
+}
 
-	if setupResult.Playwright != nil {
-		if err := setupResult.Playwright.Stop(); err != nil {
-			log.Fatalf("could not stop Playwright: %v", err)
+func printSeries(results []scrapers.SeriesResult) {
+	for i, result := range results {
+		year, episodes := "", "?"
+		if result.Year > 0 {
+			year = strconv.Itoa(result.Year)
 		}
+		if result.Episodes > 0 {
+			episodes = strconv.Itoa(result.Episodes)
+		}
+		lang := strings.ReplaceAll(result.Lang, "_", " ")
+		fmt.Printf("%3d  %-7s %4s  %4s eps  %s\n", i+1, lang, year, episodes, result.Title)
 	}
+}
 
+// askPick asks which search result to download, 0 means none
+func askPick(count int) (int, error) {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Pick a series to download [1-%d, empty to quit]: ", count)
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("could not read answer: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return 0, nil
+		}
+		if pick, convErr := strconv.Atoi(line); convErr == nil && pick >= 1 && pick <= count {
+			return pick, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		fmt.Printf("%q is not a number between 1 and %d\n", line, count)
+	}
+}
+
+func listEpisodes(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult) error {
+	scraper, err := scraperFor(setupResult.URL)
+	if err != nil {
+		slog.Error("List failed", "error", err)
+		return err
+	}
+
+	links, err := scraper.ListEpisodes(ctx, fetcher, setupResult.URL)
+	if setupResult.Output == commons.OutputJSON {
+		printLinks(setupResult.Output, setupResult.URL, links, err)
+		return err
+	}
+	if err != nil {
+		slog.Error("List failed", "error", err)
+		return err
+	}
+	for _, link := range links {
+		fmt.Printf("%5s  %-20s %s\n", link.Episode, link.Title, link.URL)
+	}
+	return nil
+}
+
+func closeBrowser(setupResult commons.SetupResult) {
+	setupResult.Browser.Close()
+}
+
+func printLinks(output commons.OutputFormat, url string, links []scrapers.EpisodeLink, err error) {
+	if output == commons.OutputJSON {
+		result := struct {
+			URL      string                 `json:"url"`
+			Episodes []scrapers.EpisodeLink `json:"episodes"`
+			Error    string                 `json:"error,omitempty"`
+		}{URL: url, Episodes: links}
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			slog.Error("could not write JSON output", "error", err)
+		}
+		return
+	}
+
+	if err != nil {
+		slog.Error("Search failed", "error", err)
+		return
+	}
+	for _, link := range links {
+		switch {
+		case link.Error != "":
+			fmt.Printf("Episode %s: error: %s\n", link.Episode, link.Error)
+		case link.StreamURL != "":
+			fmt.Printf("Episode %s: %s\n", link.Episode, link.StreamURL)
+		default:
+			fmt.Printf("Episode %s: %s\n", link.Episode, link.URL)
+		}
+	}
+}
+
+func exportLinks(format commons.ExportFormat, path string, links []scrapers.EpisodeLink) error {
+	if path == "" {
+		return scrapers.ExportLinks(os.Stdout, format, links)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create export file: %w", err)
+	}
+	defer file.Close()
+
+	if err := scrapers.ExportLinks(file, format, links); err != nil {
+		return err
+	}
+	slog.Info("Exported links", "path", path)
+	return nil
 }
@@ -0,0 +1,99 @@
+package scrapers
+
+import (
+	"fmt"
+	"github.com/playwright-community/playwright-go"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consoleLog records the console messages of a browser page
+type consoleLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *consoleLog) onConsole(message playwright.ConsoleMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, fmt.Sprintf("[%s] %s", message.Type(), message.Text()))
+}
+
+func (c *consoleLog) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strings.Join(c.lines, "\n")
+}
+
+// pageFailure is what is known about a page that didn't load as expected.
+// page and console are only set in the browser.
+type pageFailure struct {
+	URL     string
+	Reason  string
+	Doc     *Document
+	Page    playwright.Page
+	Console *consoleLog
+}
+
+// saveDebug writes the artifacts of a failed page to a folder of its own in
+// the debug directory. Every artifact is optional, the ones that can't be
+// taken are skipped.
+func (f *Fetcher) saveDebug(failure pageFailure) {
+	if f.options.DebugDir == "" {
+		return
+	}
+	dir := filepath.Join(f.options.DebugDir, time.Now().Format("20060102-150405.000")+"-"+debugName(failure.URL))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Warn("could not create debug directory", "dir", dir, "error", err)
+		return
+	}
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			slog.Warn("could not save debug artifact", "file", name, "error", err)
+		}
+	}
+
+	write("error.txt", fmt.Appendf(nil, "url: %s\nerror: %s\n", failure.URL, failure.Reason))
+
+	content := ""
+	if failure.Doc != nil {
+		content = failure.Doc.HTML()
+	} else if failure.Page != nil {
+		content, _ = failure.Page.Content()
+	}
+	if content != "" {
+		write("page.html", []byte(content))
+	}
+
+	if failure.Page != nil {
+		if screenshot, err := failure.Page.Screenshot(playwright.PageScreenshotOptions{FullPage: playwright.Bool(true)}); err == nil {
+			write("screenshot.png", screenshot)
+		} else {
+			slog.Debug("could not take screenshot", "error", err)
+		}
+		if failure.Console != nil {
+			write("console.log", []byte(failure.Console.String()))
+		}
+		if err := f.browser.SaveTrace(filepath.Join(dir, "trace.zip")); err != nil {
+			slog.Debug("could not save trace", "error", err)
+		}
+	}
+	slog.Warn("Saved debug artifacts", "url", failure.URL, "dir", dir)
+}
+
+// debugName turns the last part of a page URL into a folder name
+func debugName(pageURL string) string {
+	name := "page"
+	if parsedURL, err := url.Parse(pageURL); err == nil {
+		if base := path.Base(strings.TrimSuffix(parsedURL.Path, "/")); base != "." && base != "/" {
+			name = base
+		}
+	}
+	return cleanFilename(name)
+}
@@ -1,73 +1,92 @@
 package scrapers
 
 import (
-	"fmt"
+	"otakucrawler/commons"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
-// ParseEpisodeRange parses a string like "3-7" into start and end indices
-func ParseEpisodeRange(rangeStr string) (int, int, error) {
-	parts := strings.Split(rangeStr, "-")
-	if len(parts) != 2 {
-		return 0, 0, fmt.Errorf("invalid range format, expected 'start-end'")
-	}
-
-	start, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid start value: %w", err)
-	}
-
-	end, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid end value: %w", err)
-	}
-
-	if start < 1 || end < start {
-		return 0, 0, fmt.Errorf("invalid range: start must be ≥ 1 and end must be ≥ start")
-	}
-
-	// Convert to 0-based indices for internal use
-	return start - 1, end - 1, nil
+// Episode is an entry of a series episode list, numbered the way the site
+// numbers it rather than by its position
+type Episode struct {
+	Index   int     // position in the site's list
+	Label   string  // as shown by the site without the "Episodio" prefix, e.g. "12.5" or "OVA"
+	Number  float64 // episode number, for specials their position among the specials
+	Special bool    // OVA, movie or special without a regular episode number
+	Title   string  // text of the entry as shown by the site
+	URL     string  // episode page, empty when the entry has no link
 }
 
-// ParseSpecificEpisodes parses a string like "1,3,5" into a slice of indices
-func ParseSpecificEpisodes(episodesStr string) ([]int, error) {
-	parts := strings.Split(episodesStr, ",")
-	episodes := make([]int, 0, len(parts))
+var (
+	episodePrefixRegex = regexp.MustCompile(`(?i)^(episodio|episode|ep\.?)\s*`)
+	episodeNumberRegex = regexp.MustCompile(`^\d+([.,]\d+)?$`)
+	specialLabelRegex  = regexp.MustCompile(`(?i)\b(ova|ona|oav|special|speciale|film|movie)\b`)
+)
 
-	for _, part := range parts {
-		ep, err := strconv.Atoi(strings.TrimSpace(part))
-		if err != nil {
-			return nil, fmt.Errorf("invalid episode number '%s': %w", part, err)
-		}
+// parseEpisodes turns the labels of an episode list into episodes. Entries
+// without a label fall back to their position.
+func parseEpisodes(labels []string) []Episode {
+	episodes := make([]Episode, 0, len(labels))
+	specials := 0
+	for idx, text := range labels {
+		episode := Episode{Index: idx, Title: strings.Join(strings.Fields(text), " ")}
+		label := strings.TrimSpace(episodePrefixRegex.ReplaceAllString(episode.Title, ""))
 
-		if ep < 1 {
-			return nil, fmt.Errorf("episode numbers must be ≥ 1")
+		switch {
+		case label == "":
+			episode.Label = strconv.Itoa(idx + 1)
+			episode.Number = float64(idx + 1)
+		case episodeNumberRegex.MatchString(label) && !specialLabelRegex.MatchString(text):
+			episode.Number, _ = strconv.ParseFloat(strings.Replace(label, ",", ".", 1), 64)
+			episode.Label = formatEpisodeNumber(episode.Number)
+		default:
+			specials++
+			episode.Label = label
+			episode.Number = float64(specials)
+			episode.Special = true
 		}
-
-		// Convert to 0-based index for internal use
-		episodes = append(episodes, ep-1)
+		episodes = append(episodes, episode)
 	}
-
-	return episodes, nil
+	return episodes
 }
 
-// ShouldProcessEpisode checks if an episode should be processed based on range or specific episodes
-func ShouldProcessEpisode(idx int, start, end int, specificEpisodes []int) bool {
-	if start >= 0 && end >= 0 {
-		return idx >= start && idx <= end
+// name returns the naming values of the episode, a regular one of season.
+// Specials go to season 0, where media servers look for them.
+func (e Episode) name(series string, season int, lang, title string) commons.EpisodeName {
+	if e.Special {
+		season = 0
+	}
+	return commons.EpisodeName{
+		Series:  series,
+		Season:  season,
+		Episode: e.Number,
+		Special: e.Special,
+		Lang:    lang,
+		Title:   title,
 	}
+}
+
+// formatEpisodeNumber prints 12 as "12" and 12.5 as "12.5"
+func formatEpisodeNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
 
-	if specificEpisodes != nil {
-		for _, ep := range specificEpisodes {
-			if idx == ep {
-				return true
-			}
+// selectEpisodes applies selector to episodes. isDownloaded is only called
+// when the selector needs it, and may be nil otherwise.
+func selectEpisodes(selector *commons.EpisodeSelector, episodes []Episode, isDownloaded func(Episode) bool) ([]Episode, []string) {
+	refs := make([]commons.EpisodeRef, len(episodes))
+	for i, episode := range episodes {
+		refs[i] = commons.EpisodeRef{Label: episode.Label, Number: episode.Number, Special: episode.Special}
+		if isDownloaded != nil {
+			refs[i].Downloaded = isDownloaded(episode)
 		}
-		return false
 	}
 
-	// If no filters are specified, process all episodes
-	return true
+	indices, missing := selector.Select(refs)
+	selected := make([]Episode, 0, len(indices))
+	for _, i := range indices {
+		selected = append(selected, episodes[i])
+	}
+	return selected, missing
 }
@@ -0,0 +1,136 @@
+package scrapers
+
+import (
+	"fmt"
+	"io"
+	"otakucrawler/commons"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ExportLinks writes resolved links in a format external downloaders understand.
+// Links without a stream URL are skipped.
+func ExportLinks(w io.Writer, format commons.ExportFormat, links []EpisodeLink) error {
+	switch format {
+	case commons.ExportAria2:
+		return exportAria2(w, links)
+	case commons.ExportURLs:
+		return exportURLs(w, links)
+	case commons.ExportM3U:
+		return exportM3U(w, links)
+	case commons.ExportCrawljob:
+		return exportCrawljob(w, links)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// exportAria2 writes an aria2c input file (aria2c -i file)
+func exportAria2(w io.Writer, links []EpisodeLink) error {
+	var sb strings.Builder
+	for _, link := range links {
+		if link.StreamURL == "" {
+			continue
+		}
+		// aria2c can't assemble HLS playlists, keep them around as a hint
+		if link.HLS {
+			fmt.Fprintf(&sb, "# Episode %s is an HLS stream, aria2c can't download it: %s\n", link.Episode, link.StreamURL)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s\n", link.StreamURL)
+		if link.Filename != "" {
+			fmt.Fprintf(&sb, "  out=%s\n", link.Filename)
+		}
+		for _, name := range sortedHeaderNames(link.Headers) {
+			fmt.Fprintf(&sb, "  header=%s: %s\n", name, link.Headers[name])
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// exportURLs writes one stream URL per line
+func exportURLs(w io.Writer, links []EpisodeLink) error {
+	var sb strings.Builder
+	for _, link := range links {
+		if link.StreamURL != "" {
+			fmt.Fprintf(&sb, "%s\n", link.StreamURL)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// exportM3U writes an extended M3U playlist, headers are passed as VLC options
+func exportM3U(w io.Writer, links []EpisodeLink) error {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	for _, link := range links {
+		if link.StreamURL == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "#EXTINF:-1,%s\n", linkTitle(link))
+		if referer := link.Headers["Referer"]; referer != "" {
+			fmt.Fprintf(&sb, "#EXTVLCOPT:http-referrer=%s\n", referer)
+		}
+		if userAgent := link.Headers["User-Agent"]; userAgent != "" {
+			fmt.Fprintf(&sb, "#EXTVLCOPT:http-user-agent=%s\n", userAgent)
+		}
+		fmt.Fprintf(&sb, "%s\n", link.StreamURL)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// exportCrawljob writes a JDownloader folder watch crawljob. The format has
+// no field for request headers, so they're kept in the comment.
+func exportCrawljob(w io.Writer, links []EpisodeLink) error {
+	var sb strings.Builder
+	for _, link := range links {
+		if link.StreamURL == "" {
+			continue
+		}
+		sb.WriteString("->NEW ENTRY<-\n")
+		fmt.Fprintf(&sb, "text=%s\n", link.StreamURL)
+		if link.Filename != "" {
+			fmt.Fprintf(&sb, "filename=%s\n", link.Filename)
+			fmt.Fprintf(&sb, "packageName=%s\n", packageName(link.Filename))
+		}
+		var headers []string
+		for _, name := range sortedHeaderNames(link.Headers) {
+			headers = append(headers, name+": "+link.Headers[name])
+		}
+		if len(headers) > 0 {
+			fmt.Fprintf(&sb, "comment=%s\n", strings.Join(headers, "; "))
+		}
+		sb.WriteString("autoConfirm=TRUE\n")
+		sb.WriteString("autoStart=TRUE\n\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func linkTitle(link EpisodeLink) string {
+	if link.Filename != "" {
+		return strings.TrimSuffix(link.Filename, path.Ext(link.Filename))
+	}
+	return fmt.Sprintf("Episode %s", link.Episode)
+}
+
+// packageName groups episodes of the same series, filenames look like AnimeName_Ep_XX_LANG.mp4
+func packageName(filename string) string {
+	if idx := strings.Index(filename, "_Ep_"); idx > 0 {
+		return filename[:idx]
+	}
+	return strings.TrimSuffix(filename, path.Ext(filename))
+}
+
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,314 @@
+package scrapers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/playwright-community/playwright-go"
+	"golang.org/x/time/rate"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"otakucrawler/commons"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpUserAgent is sent with plain HTTP requests, sites serve bare Go
+// clients a challenge page more often than browsers
+const httpUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+// maxPageSize caps the HTML read from a page
+const maxPageSize = 16 << 20
+
+// streamWait is how long a loaded page that isn't ready yet is given to
+// request its stream
+const streamWait = 10 * time.Second
+
+// Fetcher loads the pages scrapers read. With the auto engine pages come
+// over plain HTTP. A page that loads but doesn't have what the scraper looks
+// for is tried in the browser, and when that works the rest of the site is
+// loaded there.
+// Requests to the same site are spaced out by the request delay, however
+// many resolvers are loading pages.
+type Fetcher struct {
+	options commons.FetchOptions
+	browser *commons.Browser
+	client  *http.Client
+
+	mu           sync.Mutex
+	needsBrowser map[string]bool          // hosts whose pages need the browser
+	limiters     map[string]*rate.Limiter // per host
+	userAgent    string                   // of the browser, once started
+}
+
+func NewFetcher(browser *commons.Browser, options commons.FetchOptions) *Fetcher {
+	if options.Resolvers < 1 {
+		options.Resolvers = 1
+	}
+	return &Fetcher{
+		options:      options,
+		browser:      browser,
+		client:       &http.Client{Timeout: time.Minute, Jar: http.DefaultClient.Jar}, // cookies loaded by CommonSetup
+		needsBrowser: map[string]bool{},
+		limiters:     map[string]*rate.Limiter{},
+	}
+}
+
+// Fetch loads pageURL. ready reports whether the page has what the caller
+// needs, when it doesn't over HTTP the page is loaded in the browser, and
+// in the browser its scripts are given time to finish. Pages refused with
+// a status challenges use are tried in the browser too, other HTTP errors
+// are returned as they are. A nil ready accepts any page.
+func (f *Fetcher) Fetch(ctx context.Context, pageURL string, ready func(*Document) bool) (*Document, error) {
+	if ready == nil {
+		ready = func(*Document) bool { return true }
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	host := hostOf(pageURL)
+	engine := f.options.Engine
+	f.mu.Lock()
+	useBrowser := engine == commons.EngineBrowser || (engine == commons.EngineAuto && f.needsBrowser[host])
+	f.mu.Unlock()
+	if useBrowser {
+		return f.fetchBrowser(ctx, pageURL, ready)
+	}
+
+	// Other errors are the site's answer, the browser would get the same
+	// one. A challenge only holds up the page it was sent for, so the site
+	// isn't switched to the browser for it.
+	doc, err := f.fetchHTTP(ctx, pageURL)
+	if err != nil {
+		var status *statusError
+		if engine == commons.EngineAuto && errors.As(err, &status) && status.challenge() {
+			slog.Debug("Page refused over plain HTTP, trying the browser", "host", host, "url", pageURL, "status", status.status)
+			return f.fetchBrowser(ctx, pageURL, ready)
+		}
+		if ctx.Err() == nil {
+			f.saveDebug(pageFailure{URL: pageURL, Reason: err.Error()})
+		}
+		return nil, err
+	}
+	if ready(doc) {
+		return doc, nil
+	}
+	if engine == commons.EngineHTTP {
+		f.saveDebug(pageFailure{URL: pageURL, Reason: notReadyReason(nil), Doc: doc})
+		return doc, nil
+	}
+
+	// The page may need its scripts to build its content. The site is
+	// only loaded in the browser from then on when that was what it took,
+	// a page that just doesn't have it, like a search without results,
+	// doesn't count.
+	slog.Debug("Page not ready over plain HTTP, trying the browser", "host", host, "url", pageURL)
+	doc, err = f.fetchBrowser(ctx, pageURL, ready)
+	if err == nil && ready(doc) {
+		slog.Debug("Using the browser for the site", "host", host)
+		f.mu.Lock()
+		f.needsBrowser[host] = true
+		f.mu.Unlock()
+	}
+	return doc, err
+}
+
+// UserAgent returns the user agent pages were loaded with, which stream
+// servers may expect downloads to use too
+func (f *Fetcher) UserAgent() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.userAgent != "" {
+		return f.userAgent
+	}
+	return httpUserAgent
+}
+
+// forEach calls fn for indexes 0 to n-1, up to Resolvers at once. Calls
+// that haven't started when ctx is cancelled are skipped.
+func (f *Fetcher) forEach(ctx context.Context, n int, fn func(i int)) {
+	sem := make(chan struct{}, f.options.Resolvers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range n {
+		select {
+		case <-ctx.Done():
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+}
+
+// wait holds a request back until the request delay of its host has
+// passed since the previous one
+func (f *Fetcher) wait(ctx context.Context, pageURL string) error {
+	if f.options.RequestDelay <= 0 {
+		return nil
+	}
+	host := hostOf(pageURL)
+	f.mu.Lock()
+	limiter, ok := f.limiters[host]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(f.options.RequestDelay), 1)
+		f.limiters[host] = limiter
+	}
+	f.mu.Unlock()
+	return limiter.Wait(ctx)
+}
+
+func (f *Fetcher) fetchHTTP(ctx context.Context, pageURL string) (*Document, error) {
+	if err := f.wait(ctx, pageURL); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("User-Agent", httpUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("Accept-Language", "it-IT,it;q=0.9,en;q=0.8")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{url: pageURL, code: resp.StatusCode, status: resp.Status}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", pageURL, err)
+	}
+	return parseDocument(resp.Request.URL.String(), string(body))
+}
+
+// statusError is a page answered with a status other than 200
+type statusError struct {
+	url    string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("could not load %s: %s", e.url, e.status)
+}
+
+// challenge reports whether the status is one sites answer with while
+// checking the client with a script
+func (e *statusError) challenge() bool {
+	switch e.code {
+	case http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+func (f *Fetcher) fetchBrowser(ctx context.Context, pageURL string, ready func(*Document) bool) (*Document, error) {
+	if f.browser == nil {
+		return nil, fmt.Errorf("could not load %s: no browser available", pageURL)
+	}
+	page, err := f.browser.AcquirePage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer f.browser.ReleasePage(page)
+
+	f.mu.Lock()
+	if f.userAgent == "" {
+		f.userAgent = browserUserAgent(page)
+	}
+	f.mu.Unlock()
+
+	// Media requests are recorded as they happen, players that build their
+	// stream URL in scripts or iframes leave no trace in the HTML
+	capture := newStreamCapture()
+	page.On("response", capture.onResponse)
+	defer page.RemoveListener("response", capture.onResponse)
+
+	var console *consoleLog
+	if f.options.DebugDir != "" {
+		console = &consoleLog{}
+		page.On("console", console.onConsole)
+		defer page.RemoveListener("console", console.onConsole)
+	}
+
+	doc, err := f.loadInBrowser(ctx, page, pageURL, ready, capture)
+	if ctx.Err() == nil && (err != nil || !ready(doc)) {
+		f.saveDebug(pageFailure{URL: pageURL, Reason: notReadyReason(err), Doc: doc, Page: page, Console: console})
+	}
+	return doc, err
+}
+
+// loadInBrowser navigates page to pageURL and waits until it is ready, or
+// as long as it makes sense to
+func (f *Fetcher) loadInBrowser(ctx context.Context, page playwright.Page, pageURL string, ready func(*Document) bool, capture *streamCapture) (*Document, error) {
+	if err := f.wait(ctx, pageURL); err != nil {
+		return nil, err
+	}
+	if _, err := page.Goto(pageURL, playwright.PageGotoOptions{WaitUntil: playwright.WaitUntilStateDomcontentloaded}); err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", pageURL, err)
+	}
+	doc, err := pageDocument(page, capture)
+	if err != nil || ready(doc) || ctx.Err() != nil {
+		return doc, err
+	}
+
+	// Content added by scripts needs the page to finish loading
+	if err := page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{State: playwright.LoadStateLoad}); err != nil {
+		return nil, fmt.Errorf("could not wait for %s to load: %w", pageURL, err)
+	}
+	if doc, err = pageDocument(page, capture); err != nil || ready(doc) {
+		return doc, err
+	}
+
+	// Players may only request their stream once set up after loading
+	select {
+	case <-capture.found:
+	case <-time.After(streamWait):
+	case <-ctx.Done():
+	}
+	return pageDocument(page, capture)
+}
+
+// notReadyReason describes why a page was not usable
+func notReadyReason(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "the page is missing what the scraper looks for"
+}
+
+// pageDocument parses the current DOM of a browser page, with the media
+// requests captured so far
+func pageDocument(page playwright.Page, capture *streamCapture) (*Document, error) {
+	content, err := page.Content()
+	if err != nil {
+		return nil, fmt.Errorf("could not get page content: %w", err)
+	}
+	doc, err := parseDocument(page.URL(), content)
+	if err != nil {
+		return nil, err
+	}
+	doc.streams = capture.list()
+	return doc, nil
+}
+
+func hostOf(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return strings.ToLower(parsedURL.Hostname())
+}
@@ -0,0 +1,56 @@
+package scrapers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"otakucrawler/commons"
+	"strings"
+	"testing"
+)
+
+// Without a browser, a page tried in it fails with "no browser available"
+func TestFetchHTTPErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/challenge":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("<html><body><p>ok</p></body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		browser bool // tried in the browser
+	}{
+		{"/gone", false},
+		{"/challenge", true},
+		{"/limited", true},
+	}
+	f := NewFetcher(nil, commons.FetchOptions{Engine: commons.EngineAuto})
+	for _, tt := range tests {
+		_, err := f.Fetch(context.Background(), server.URL+tt.path, nil)
+		if err == nil {
+			t.Fatalf("%s: expected an error", tt.path)
+		}
+		var status *statusError
+		if tried := strings.Contains(err.Error(), "no browser available"); tried != tt.browser {
+			t.Errorf("%s: tried in the browser = %v, want %v (%v)", tt.path, tried, tt.browser, err)
+		}
+		if !tt.browser && !errors.As(err, &status) {
+			t.Errorf("%s: expected the HTTP status, got %v", tt.path, err)
+		}
+	}
+
+	// A challenge doesn't switch the site to the browser
+	if _, err := f.Fetch(context.Background(), server.URL+"/", nil); err != nil {
+		t.Errorf("page after a challenge: %v", err)
+	}
+}
@@ -0,0 +1,736 @@
+package scrapers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"otakucrawler/commons"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// GenericScraper reads a site with the selectors and patterns of its
+// definition: a search page, a series page listing its episodes, episode
+// pages that may link to a separate player page, and a player with an MP4
+// source or an HLS playlist. See sites/animesaturn.yaml.
+type GenericScraper struct {
+	site *SiteDefinition
+}
+
+// openSeries loads the series page
+func openSeries(ctx context.Context, f *Fetcher, def *SiteDefinition, seriesURL string) (*Document, error) {
+	doc, err := f.Fetch(ctx, seriesURL, def.seriesReady)
+	if err != nil {
+		return nil, fmt.Errorf("could not open series page: %w", err)
+	}
+	return doc, nil
+}
+
+func (s *GenericScraper) GetLinks(ctx context.Context, f *Fetcher, seriesURL string, resolve bool) ([]EpisodeLink, error) {
+	def := s.site
+	doc, err := openSeries(ctx, f, def, seriesURL)
+	if err != nil {
+		return nil, err
+	}
+	episodes, err := seriesEpisodes(def, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var animeName, languageType string
+	if resolve {
+		animeName, languageType = extractAnimeName(def, doc)
+	}
+
+	// Episodes are resolved by several pages at once, the links keep the
+	// order of the episodes
+	links := make([]EpisodeLink, len(episodes))
+	f.forEach(ctx, len(episodes), func(i int) {
+		episode := episodes[i]
+		link := EpisodeLink{Episode: episode.Label, Special: episode.Special}
+
+		stream, err := openEpisode(ctx, f, def, episode.URL, resolve)
+		link.URL = stream.PageURL
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("could not resolve episode", "episode", episode.Label, "error", err)
+			}
+			link.Error = err.Error()
+		} else if resolve {
+			link.StreamURL = stream.VideoURL
+			link.HLS = stream.IsHLS
+			ext := "mp4"
+			if !stream.IsHLS {
+				ext = videoExtension(stream.VideoURL)
+			}
+			name := episode.name(animeName, 1, languageType, episode.Title)
+			name.Ext = ext
+			link.Filename = episodeFilename(name)
+			link.Headers = streamHeaders(stream.PageURL, f.UserAgent())
+		}
+		links[i] = link
+	})
+	if ctx.Err() != nil {
+		// Keep the episodes resolved before the interruption
+		return slices.DeleteFunc(links, func(link EpisodeLink) bool { return link.Episode == "" }), ctx.Err()
+	}
+	return links, nil
+}
+
+// seriesEpisodes reads the episode buttons of the series page, with the
+// episode pages they link to
+func seriesEpisodes(def *SiteDefinition, doc *Document) ([]Episode, error) {
+	buttons := doc.Find(def.Series.Episodes)
+	if len(buttons) == 0 {
+		return nil, fmt.Errorf("could not get entries: no episodes found")
+	}
+
+	labels := make([]string, len(buttons))
+	for idx, button := range buttons {
+		labels[idx] = button.Text()
+	}
+
+	episodes := parseEpisodes(labels)
+	for idx, button := range buttons {
+		if href := button.Attr("href"); href != "" {
+			episodes[idx].URL = resolveURL(doc.URL(), href)
+		}
+	}
+	return episodes, nil
+}
+
+// ListEpisodes reads the episode buttons of the series page without opening
+// any of them, so it is much faster than a search
+func (s *GenericScraper) ListEpisodes(ctx context.Context, f *Fetcher, seriesURL string) ([]EpisodeLink, error) {
+	def := s.site
+	doc, err := openSeries(ctx, f, def, seriesURL)
+	if err != nil {
+		return nil, err
+	}
+	episodes, err := seriesEpisodes(def, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]EpisodeLink, 0, len(episodes))
+	for _, episode := range episodes {
+		links = append(links, EpisodeLink{Episode: episode.Label, Special: episode.Special, Title: episode.Title, URL: episode.URL})
+	}
+	return links, nil
+}
+
+// FindSeries searches the site's series list for query
+func (s *GenericScraper) FindSeries(ctx context.Context, f *Fetcher, site, query string) ([]SeriesResult, error) {
+	def := s.site
+	searchURL, err := def.searchURL(site, query)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Searching series", "url", searchURL)
+	doc, err := f.Fetch(ctx, searchURL, def.searchReady)
+	if err != nil {
+		return nil, fmt.Errorf("could not open search page: %w", err)
+	}
+
+	var results []SeriesResult
+	for _, item := range doc.Find(def.Search.Item) {
+		titleLinks := item.Find(def.Search.Link)
+		if len(titleLinks) == 0 {
+			continue
+		}
+		href := titleLinks[0].Attr("href")
+		if href == "" {
+			continue
+		}
+
+		result := SeriesResult{URL: resolveURL(doc.URL(), href)}
+		result.Title, result.Lang = def.splitLanguage(titleLinks[0].Text())
+
+		// Year and episode count are only in the free text of the entry
+		text := item.Text()
+		if match := yearRegex.FindString(text); match != "" {
+			result.Year, _ = strconv.Atoi(match)
+		}
+		if def.Search.Episodes.Regexp != nil {
+			if match := def.Search.Episodes.FindStringSubmatch(text); len(match) >= 2 {
+				result.Episodes, _ = strconv.Atoi(match[1])
+			}
+		}
+		results = append(results, result)
+	}
+	slog.Debug("Search results", "query", query, "count", len(results))
+	return results, nil
+}
+
+// Variants returns the series followed by its versions in other
+// languages. The site lists them as separate series, so they are looked up
+// by title. Sites without a search only have the series itself. When the
+// lookup fails the series is returned with the error.
+func (s *GenericScraper) Variants(ctx context.Context, f *Fetcher, seriesURL string) ([]SeriesResult, error) {
+	def := s.site
+	doc, err := openSeries(ctx, f, def, seriesURL)
+	if err != nil {
+		return nil, err
+	}
+	current := SeriesResult{URL: doc.URL()}
+	current.Title, current.Lang = extractAnimeName(def, doc)
+	if def.Search.URL == "" {
+		return []SeriesResult{current}, nil
+	}
+
+	pageURL, err := url.Parse(current.URL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse series URL: %w", err)
+	}
+	site := pageURL.Scheme + "://" + pageURL.Host
+
+	results, err := s.FindSeries(ctx, f, site, strings.ReplaceAll(current.Title, "_", " "))
+	if err != nil {
+		return []SeriesResult{current}, fmt.Errorf("could not look up other versions: %w", err)
+	}
+
+	variants := []SeriesResult{current}
+	for _, result := range results {
+		if slices.ContainsFunc(variants, func(v SeriesResult) bool { return v.Lang == result.Lang }) {
+			continue
+		}
+		if sameSeries(current, result) {
+			variants = append(variants, result)
+		}
+	}
+	return variants, nil
+}
+
+// sameSeries reports whether b is another version of a, the dubbed ones
+// usually differ only by a -ITA suffix in the URL
+func sameSeries(a, b SeriesResult) bool {
+	if strings.EqualFold(cleanFilename(a.Title), cleanFilename(b.Title)) {
+		return true
+	}
+	pathA, pathB := strings.TrimSuffix(a.URL, "/"), strings.TrimSuffix(b.URL, "/")
+	return strings.EqualFold(pathA+"-ITA", pathB) || strings.EqualFold(pathA, pathB+"-ITA")
+}
+
+// relatedLinks returns the links of the related section. It has no
+// stable id, without one of the known containers it is found by its heading.
+func relatedLinks(def *SiteDefinition, doc *Document) []string {
+	var links []*Element
+	for _, selector := range def.Series.Related {
+		if links = doc.Find(selector); len(links) > 0 {
+			break
+		}
+	}
+	if len(links) == 0 && def.Series.RelatedHeading.Regexp != nil && def.Series.RelatedLink != "" {
+		for _, heading := range doc.Find("h1, h2, h3, h4, h5, span, b") {
+			if def.Series.RelatedHeading.MatchString(heading.Text()) {
+				if links = heading.Parent().Find(def.Series.RelatedLink); len(links) > 0 {
+					break
+				}
+			}
+		}
+	}
+
+	var hrefs []string
+	for _, link := range links {
+		if href := link.Attr("href"); href != "" {
+			hrefs = append(hrefs, resolveURL(doc.URL(), href))
+		}
+	}
+	return hrefs
+}
+
+// Seasons returns the series and the seasons and movies linked in
+// its related section, in the same language and ordered by year. Every
+// entry is opened to read its year.
+func (s *GenericScraper) Seasons(ctx context.Context, f *Fetcher, seriesURL string) ([]SeriesResult, error) {
+	def := s.site
+	doc, err := openSeries(ctx, f, def, seriesURL)
+	if err != nil {
+		return nil, err
+	}
+
+	current := seasonEntry(def, doc)
+	seasons := []SeriesResult{current}
+	seen := map[string]bool{strings.TrimSuffix(doc.URL(), "/"): true}
+	for _, href := range relatedLinks(def, doc) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		key := strings.TrimSuffix(href, "/")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		related, err := f.Fetch(ctx, href, def.seriesReady)
+		if err != nil {
+			slog.Warn("could not open related entry", "url", href, "error", err)
+			continue
+		}
+		entry := seasonEntry(def, related)
+		if entry.Lang != current.Lang {
+			slog.Debug("Skipping related entry in another language", "url", href, "lang", entry.Lang)
+			continue
+		}
+		seasons = append(seasons, entry)
+	}
+
+	// Entries without a year keep their place after the dated ones
+	slices.SortStableFunc(seasons, func(a, b SeriesResult) int {
+		switch {
+		case a.Year == b.Year:
+			return 0
+		case a.Year == 0:
+			return 1
+		case b.Year == 0:
+			return -1
+		default:
+			return a.Year - b.Year
+		}
+	})
+	slog.Info("Seasons found", "count", len(seasons))
+	return seasons, nil
+}
+
+// seasonEntry describes the series page doc
+func seasonEntry(def *SiteDefinition, doc *Document) SeriesResult {
+	entry := SeriesResult{URL: doc.URL()}
+	entry.Title, entry.Lang = extractAnimeName(def, doc)
+	entry.Year = readSeriesInfo(def, doc, entry.Title).Year
+	entry.Episodes = len(doc.Find(def.Series.Episodes))
+	return entry
+}
+
+type EpisodeDownload struct {
+	Episode      Episode
+	VideoUrl     string
+	IsHLS        bool
+	AnimeName    string
+	LanguageType string
+	Title        string
+	PageURL      string // streaming page, recorded as the source in tags
+}
+
+func extractAnimeName(def *SiteDefinition, doc *Document) (string, string) {
+	var animeName, languageType string
+
+	for _, selector := range def.Series.Title {
+		if title := doc.Value(selector); title != "" {
+			// Extract language type (SUB_ITA or ITA)
+			animeName, languageType = def.splitLanguage(title)
+
+			// Clean the anime name for filename use
+			animeName = cleanFilename(animeName)
+
+			if animeName != "" {
+				slog.Info("Extracted anime name", "name", animeName, "language", languageType)
+				return animeName, languageType
+			}
+		}
+	}
+
+	// Fallback if we couldn't extract the name
+	slog.Warn("Could not extract anime name from main page, using fallback")
+	return "Unknown_Anime", commons.LangSubITA
+}
+
+var yearRegex = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+
+// readSeriesInfo reads the show metadata from the series page. Every
+// field is optional, missing ones are left empty.
+func readSeriesInfo(def *SiteDefinition, doc *Document, animeName string) SeriesInfo {
+	info := SeriesInfo{Title: animeName}
+
+	// The plot is cut short in #shown-trama, #full-trama has all of it
+	info.Plot = firstValue(doc, def.Series.Plot)
+
+	if src := firstValue(doc, def.Series.Poster); src != "" {
+		info.PosterURL = resolveURL(doc.URL(), src)
+	}
+
+	// Release date, e.g. "Data di uscita: 5 Ottobre 2019"
+	if def.Series.ReleaseDate != "" {
+		details := doc.First(def.Series.ReleaseDate).Parent().Text()
+		if match := yearRegex.FindString(details); match != "" {
+			fmt.Sscanf(match, "%d", &info.Year)
+		}
+	}
+
+	for _, genre := range doc.Find(def.Series.Genres) {
+		if text := genre.Text(); text != "" {
+			info.Genres = append(info.Genres, text)
+		}
+	}
+
+	slog.Debug("Extracted series info", "title", info.Title, "year", info.Year, "poster", info.PosterURL, "genres", info.Genres)
+	return info
+}
+
+type episodeStream struct {
+	PageURL  string // streaming page, also the referer the CDN expects
+	VideoURL string
+	IsHLS    bool
+}
+
+// openEpisode follows an episode page to its streaming page, when the site
+// has one. When extract is set it also pulls the video URL out of the player.
+func openEpisode(ctx context.Context, f *Fetcher, def *SiteDefinition, episodeURL string, extract bool) (episodeStream, error) {
+	var stream episodeStream
+	if episodeURL == "" {
+		return stream, fmt.Errorf("episode has no link")
+	}
+
+	ready := def.playerReady
+	if !extract {
+		ready = nil
+	}
+
+	// Without a streaming button the player is on the episode page
+	episodeReady := def.episodeReady
+	if def.Episode.StreamingLink == "" {
+		episodeReady = ready
+	}
+	doc, err := f.Fetch(ctx, episodeURL, episodeReady)
+	if err != nil {
+		return stream, fmt.Errorf("could not open episode page: %w", err)
+	}
+
+	// The streaming button links to the player page
+	if def.Episode.StreamingLink != "" {
+		if streamingURL := doc.Link(def.Episode.StreamingLink); streamingURL == "" {
+			slog.Warn("Could not find streaming button", "url", episodeURL)
+		} else {
+			doc, err = f.Fetch(ctx, streamingURL, ready)
+			if err != nil {
+				return stream, fmt.Errorf("could not open streaming page: %w", err)
+			}
+		}
+	}
+
+	stream.PageURL = doc.URL()
+	if !extract {
+		return stream, nil
+	}
+	stream.VideoURL, stream.IsHLS, err = findVideoSource(def, doc)
+	return stream, err
+}
+
+// findVideoSource returns the stream the player requested when the page
+// was loaded in the browser. Otherwise it reads the MP4 source of the
+// player, or else the HLS playlist its setup script points to.
+func findVideoSource(def *SiteDefinition, doc *Document) (string, bool, error) {
+	if doc.HasStreams() {
+		videoURL, isHLS, err := pickCapturedStream(doc.streams)
+		if err == nil {
+			return videoURL, isHLS, nil
+		}
+		slog.Debug("No usable stream captured, reading the player", "url", doc.URL(), "error", err)
+	}
+	if def.Player.MP4 != "" {
+		if src := doc.Value(def.Player.MP4); src != "" {
+			return resolveURL(doc.URL(), src), false, nil
+		}
+	}
+
+	hlsUrl, err := def.hlsURL(doc.HTML())
+	if err != nil {
+		return "", false, fmt.Errorf("could not extract video URL: %w", err)
+	}
+	return hlsUrl, true, nil
+}
+
+func (s *GenericScraper) Download(ctx context.Context, f *Fetcher, seriesURL string, selector *commons.EpisodeSelector, config commons.DownloadConfig, ffmpegPath string) error {
+	def := s.site
+	doc, err := openSeries(ctx, f, def, seriesURL)
+	if err != nil {
+		return err
+	}
+	episodes, err := seriesEpisodes(def, doc)
+	if err != nil {
+		return err
+	}
+
+	totalEpisodes := len(episodes)
+	slog.Info("Episodes found", "total", totalEpisodes)
+	config.Events.Emit(commons.Event{Event: commons.EventEpisodesFound, Count: totalEpisodes})
+
+	// Extract anime name and language type from the main page
+	animeName, languageType := extractAnimeName(def, doc)
+
+	// Seasons of a franchise are saved as one show, named after the first
+	season := 1
+	if config.Franchise != nil {
+		animeName, season = config.Franchise.Series, config.Franchise.Season
+		for i := range episodes {
+			if episodes[i].Special {
+				episodes[i].Number += float64(config.Franchise.SpecialsBefore)
+			}
+		}
+	}
+
+	// Media server layouts don't put the language in file names, so the
+	// dubbed version is kept apart as its own show
+	if config.Layout != commons.LayoutNone && languageType == commons.LangITA {
+		animeName += " (ITA)"
+	}
+
+	// Show metadata for media servers and tags
+	seriesInfo := SeriesInfo{Title: animeName}
+	if config.Layout != commons.LayoutNone || config.WriteTags {
+		seriesInfo = readSeriesInfo(def, doc, animeName)
+	}
+	if config.Franchise != nil && config.Franchise.Year > 0 {
+		seriesInfo.Year = config.Franchise.Year
+	}
+	if config.Layout != commons.LayoutNone {
+		seriesDir := config.SeriesDir(commons.EpisodeName{Series: animeName, Year: seriesInfo.Year})
+		if err := writeSeriesMetadata(ctx, seriesDir, config.Layout, seriesInfo); err != nil {
+			slog.Warn("could not write series metadata", "dir", seriesDir, "error", err)
+		}
+	}
+
+	// The poster is embedded as cover art in every episode
+	var coverPath string
+	if config.WriteTags {
+		coverPath, err = downloadCover(ctx, seriesInfo.PosterURL)
+		if err != nil {
+			slog.Warn("could not get cover art", "error", err)
+		}
+		if coverPath != "" {
+			defer os.Remove(coverPath)
+		}
+	}
+
+	// Select the episodes to process, "new" needs to know which ones are
+	// already on disk. The extension is only known once the stream is found,
+	// so any the downloader saves counts.
+	var isDownloaded func(Episode) bool
+	if selector.NeedsDownloadState() {
+		isDownloaded = func(episode Episode) bool {
+			name := episode.name(animeName, season, languageType, episode.Title)
+			name.Year = seriesInfo.Year
+			for _, ext := range videoExtensions {
+				name.Ext = ext
+				if _, err := os.Stat(config.EpisodePath(name)); err == nil {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	episodesToProcess, missing := selectEpisodes(selector, episodes, isDownloaded)
+	for _, label := range missing {
+		slog.Warn("Requested episode not available", "episode", label)
+	}
+
+	if len(episodesToProcess) == 0 {
+		if len(missing) == 0 && selector.NeedsDownloadState() {
+			slog.Info("No new episodes", "series", animeName)
+			return nil
+		}
+		return fmt.Errorf("no episodes to process after applying filters")
+	}
+
+	slog.Info("Will download episodes", "count", len(episodesToProcess))
+
+	batchSize := config.BatchSize
+	var speedPerDownload float64
+
+	if config.MaxSpeedMbps > 0 {
+		speedPerDownload = config.MaxSpeedMbps / float64(batchSize)
+		slog.Info("Download limits", "batch_size", batchSize, "max_speed_mbps", config.MaxSpeedMbps, "per_download_mbps", speedPerDownload)
+	} else {
+		speedPerDownload = 0 // No limit
+		slog.Info("Download limits", "batch_size", batchSize, "max_speed_mbps", "unlimited")
+	}
+
+	// Route console logs through the progress tracker so concurrent downloads
+	// don't interleave with the bars
+	progress := commons.NewProgress(os.Stderr)
+	progress.SetTotalEpisodes(len(episodesToProcess))
+	progress.Start()
+	commons.SetConsoleOutput(progress)
+	defer func() {
+		commons.SetConsoleOutput(nil)
+		progress.Stop()
+	}()
+
+	var failed atomic.Int32
+
+	// Episodes are resolved ahead of the downloads on all the resolvers, up
+	// to as many batches ahead as keep them busy. Stream URLs can expire, so
+	// they aren't all resolved at the start.
+	numBatches := (len(episodesToProcess) + batchSize - 1) / batchSize
+	batchStarted := make([]chan struct{}, numBatches)
+	for i := range batchStarted {
+		batchStarted[i] = make(chan struct{})
+	}
+	aheadBatches := max(1, (f.options.Resolvers+batchSize-1)/batchSize)
+	resolved := make([]*EpisodeDownload, len(episodesToProcess))
+	ready := make([]chan struct{}, len(episodesToProcess))
+	for i := range ready {
+		ready[i] = make(chan struct{})
+	}
+
+	var resolving sync.WaitGroup
+	resolving.Add(1)
+	defer resolving.Wait()
+	go func() {
+		defer resolving.Done()
+		f.forEach(ctx, len(episodesToProcess), func(i int) {
+			defer close(ready[i])
+			if wait := i/batchSize - aheadBatches; wait >= 0 {
+				select {
+				case <-batchStarted[wait]:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			episode := episodesToProcess[i]
+			stream, err := openEpisode(ctx, f, def, episode.URL, true)
+			videoUrl, isHLS := stream.VideoURL, stream.IsHLS
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Warn("could not resolve episode", "episode", episode.Label, "error", err)
+				config.Events.Emit(commons.Event{Event: commons.EventEpisodeFailed, Episode: episode.Label, Error: err.Error()})
+				progress.Fail()
+				failed.Add(1)
+				return
+			}
+
+			slog.Debug("Found video source", "episode", episode.Label, "url", videoUrl, "hls", isHLS)
+			config.Events.Emit(commons.Event{Event: commons.EventEpisodeResolved, Episode: episode.Label, StreamURL: videoUrl, HLS: isHLS})
+
+			resolved[i] = &EpisodeDownload{
+				Episode:      episode,
+				VideoUrl:     videoUrl,
+				IsHLS:        isHLS,
+				AnimeName:    animeName,
+				LanguageType: languageType,
+				Title:        episode.Title,
+				PageURL:      stream.PageURL,
+			}
+		})
+	}()
+
+	// Process episodes in batches
+	for batchStart := 0; batchStart < len(episodesToProcess); batchStart += batchSize {
+		batchEnd := batchStart + batchSize
+		if batchEnd > len(episodesToProcess) {
+			batchEnd = len(episodesToProcess)
+		}
+		close(batchStarted[batchStart/batchSize])
+
+		currentBatch := episodesToProcess[batchStart:batchEnd]
+
+		slog.Info("Processing batch",
+			"size", len(currentBatch),
+			"first", currentBatch[0].Label,
+			"last", currentBatch[len(currentBatch)-1].Label)
+
+		for i := batchStart; i < batchEnd; i++ {
+			select {
+			case <-ready[i]:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		var batchDownloads []EpisodeDownload
+		for _, dl := range resolved[batchStart:batchEnd] {
+			if dl != nil {
+				batchDownloads = append(batchDownloads, *dl)
+			}
+		}
+
+		// Download this batch concurrently
+		var wg sync.WaitGroup
+
+		for _, dl := range batchDownloads {
+			wg.Add(1)
+			go func(dl EpisodeDownload) {
+				defer wg.Done()
+
+				label := dl.Episode.Label
+				logger := slog.With("episode", label)
+				task := progress.AddTask("Ep " + label)
+				config.Events.Emit(commons.Event{Event: commons.EventDownloadStarted, Episode: label, StreamURL: dl.VideoUrl, HLS: dl.IsHLS})
+
+				name := dl.Episode.name(dl.AnimeName, season, dl.LanguageType, dl.Title)
+				name.Year = seriesInfo.Year
+				name.Ext = "mp4" // HLS streams are remuxed to mp4
+				if !dl.IsHLS {
+					name.Ext = videoExtension(dl.VideoUrl)
+				}
+				outputPath := config.EpisodePath(name)
+
+				var tags *mediaTags
+				if config.WriteTags {
+					tags = newMediaTags(name, seriesInfo.Title, dl.PageURL, coverPath)
+				}
+
+				var result downloadResult
+				var err error
+				if dl.IsHLS {
+					result, err = downloadHLSVideo(ctx, logger, dl.VideoUrl, outputPath, ffmpegPath, config.Quality, speedPerDownload, tags, task)
+				} else {
+					result, err = downloadVideo(ctx, logger, dl.VideoUrl, outputPath, ffmpegPath, speedPerDownload, tags, task)
+				}
+				task.Done(err)
+
+				if errors.Is(err, context.Canceled) {
+					logger.Warn("Download interrupted")
+					config.Events.Emit(commons.Event{Event: commons.EventDownloadFailed, Episode: label, StreamURL: dl.VideoUrl, Error: "interrupted"})
+					return
+				}
+				if err != nil {
+					logger.Error("Download failed", "error", err)
+					config.Events.Emit(commons.Event{Event: commons.EventDownloadFailed, Episode: label, StreamURL: dl.VideoUrl, Error: err.Error()})
+					failed.Add(1)
+					return
+				}
+
+				if config.Layout.WritesNFO() {
+					if err := writeEpisodeNFO(result.Path, seriesInfo, name); err != nil {
+						logger.Warn("could not write episode metadata", "error", err)
+					}
+				}
+
+				event := commons.EventDownloadCompleted
+				if result.Skipped {
+					event = commons.EventDownloadSkipped
+				}
+				config.Events.Emit(commons.Event{Event: event, Episode: label, StreamURL: dl.VideoUrl, HLS: dl.IsHLS, Path: result.Path, Size: result.Size})
+				logger.Info("Completed download")
+			}(dl)
+		}
+
+		// Wait for all downloads in this batch to complete
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	config.Events.Emit(commons.Event{Event: commons.EventFinished, Count: len(episodesToProcess) - int(failed.Load())})
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted: %w", ctx.Err())
+	}
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("%d of %d episodes failed", n, len(episodesToProcess))
+	}
+	return nil
+}
@@ -0,0 +1,485 @@
+package scrapers
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Document is a page parsed from its HTML, whether it came over plain HTTP
+// or from the browser after its scripts ran
+type Document struct {
+	url     string
+	content string
+	root    *html.Node
+	streams []capturedStream // media the browser requested, empty over HTTP
+}
+
+// Element is an element of a Document
+type Element struct {
+	node *html.Node
+}
+
+func parseDocument(pageURL, content string) (*Document, error) {
+	root, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", pageURL, err)
+	}
+	return &Document{url: pageURL, content: content, root: root}, nil
+}
+
+// URL returns the address the page was loaded from, after redirects
+func (d *Document) URL() string {
+	return d.url
+}
+
+// HTML returns the source of the page
+func (d *Document) HTML() string {
+	return d.content
+}
+
+// HasStreams reports whether the browser requested any media while
+// loading the page
+func (d *Document) HasStreams() bool {
+	return len(d.streams) > 0
+}
+
+// Find returns the elements matching a CSS selector, in document order
+func (d *Document) Find(selector string) []*Element {
+	return findAll(d.root, selector)
+}
+
+// First returns the first element matching selector, nil if there is none
+func (d *Document) First(selector string) *Element {
+	if elements := d.Find(selector); len(elements) > 0 {
+		return elements[0]
+	}
+	return nil
+}
+
+// Has reports whether any element matches selector
+func (d *Document) Has(selector string) bool {
+	return d.First(selector) != nil
+}
+
+// Text returns the text of the first element matching selector
+func (d *Document) Text(selector string) string {
+	return d.First(selector).Text()
+}
+
+// Attr returns an attribute of the first element matching selector
+func (d *Document) Attr(selector, name string) string {
+	return d.First(selector).Attr(name)
+}
+
+// Value returns the text of the first element matching selector, or the
+// attribute named after an @ at its end, as site definitions write them
+func (d *Document) Value(selector string) string {
+	selector, name := splitAttrSelector(selector)
+	if name != "" {
+		return strings.TrimSpace(d.Attr(selector, name))
+	}
+	return d.Text(selector)
+}
+
+// splitAttrSelector separates the attribute name of "selector@name"
+func splitAttrSelector(selector string) (string, string) {
+	at := strings.LastIndexByte(selector, '@')
+	if at < 0 {
+		return selector, ""
+	}
+	name := selector[at+1:]
+	if name == "" || strings.ContainsAny(name, "'\"]) \t") {
+		return selector, ""
+	}
+	return strings.TrimSpace(selector[:at]), name
+}
+
+// Link returns the href of the first element matching selector, resolved
+// against the page
+func (d *Document) Link(selector string) string {
+	if href := d.Attr(selector, "href"); href != "" {
+		return resolveURL(d.url, href)
+	}
+	return ""
+}
+
+// Find returns the descendants of e matching a CSS selector
+func (e *Element) Find(selector string) []*Element {
+	if e == nil {
+		return nil
+	}
+	return findAll(e.node, selector)
+}
+
+// Text returns the text content of e with whitespace collapsed, empty for
+// a nil element
+func (e *Element) Text() string {
+	if e == nil {
+		return ""
+	}
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteByte(' ')
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(e.node)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// Attr returns an attribute of e, empty when it is missing
+func (e *Element) Attr(name string) string {
+	if e == nil {
+		return ""
+	}
+	for _, attr := range e.node.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// Parent returns the parent element of e, nil at the top
+func (e *Element) Parent() *Element {
+	if e == nil || e.node.Parent == nil || e.node.Parent.Type != html.ElementNode {
+		return nil
+	}
+	return &Element{node: e.node.Parent}
+}
+
+func findAll(root *html.Node, selector string) []*Element {
+	group, err := cachedSelector(selector)
+	if err != nil {
+		slog.Warn("invalid selector", "selector", selector, "error", err)
+		return nil
+	}
+
+	var found []*Element
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && group.matches(child) {
+				found = append(found, &Element{node: child})
+			}
+			walk(child)
+		}
+	}
+	walk(root)
+	return found
+}
+
+var selectorCache sync.Map
+
+func cachedSelector(selector string) (selectorGroup, error) {
+	if group, ok := selectorCache.Load(selector); ok {
+		return group.(selectorGroup), nil
+	}
+	group, err := compileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	selectorCache.Store(selector, group)
+	return group, nil
+}
+
+// The CSS subset understood by Find: type, #id, .class and attribute
+// selectors ([a], [a=v], [a~=v], [a^=v], [a$=v], [a*=v]), :contains("text")
+// for case-insensitive text matches, the descendant and child combinators
+// and comma separated lists.
+type selectorGroup []complexSelector
+
+type complexSelector []selectorStep
+
+type selectorStep struct {
+	combinator byte // ' ' or '>' towards the previous step, 0 for the first
+	tag        string
+	conditions []func(*html.Node) bool
+}
+
+func (g selectorGroup) matches(n *html.Node) bool {
+	for _, complex := range g {
+		if complex.matches(n, len(complex)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c complexSelector) matches(n *html.Node, i int) bool {
+	if !c[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for parent := n.Parent; parent != nil && parent.Type == html.ElementNode; parent = parent.Parent {
+		if c.matches(parent, i-1) {
+			return true
+		}
+		if c[i].combinator == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+func (s selectorStep) matches(n *html.Node) bool {
+	if s.tag != "" && s.tag != "*" && n.Data != s.tag {
+		return false
+	}
+	for _, condition := range s.conditions {
+		if !condition(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func compileSelector(selector string) (selectorGroup, error) {
+	p := &selectorParser{input: selector}
+	var group selectorGroup
+	for {
+		complex, err := p.complex()
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, complex)
+		p.skipSpace()
+		if p.done() {
+			return group, nil
+		}
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("unexpected %q at %d", p.peek(), p.pos)
+		}
+		p.pos++
+	}
+}
+
+type selectorParser struct {
+	input string
+	pos   int
+}
+
+func (p *selectorParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *selectorParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for !p.done() && strings.IndexByte(" \t\n\r", p.peek()) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *selectorParser) complex() (complexSelector, error) {
+	var complex complexSelector
+	var combinator byte
+	p.skipSpace()
+	for {
+		step, err := p.step()
+		if err != nil {
+			return nil, err
+		}
+		step.combinator = combinator
+		complex = append(complex, step)
+
+		spaced := p.skipSpace()
+		switch c := p.peek(); {
+		case c == 0 || c == ',':
+			return complex, nil
+		case c == '>':
+			p.pos++
+			p.skipSpace()
+			combinator = '>'
+		case spaced:
+			combinator = ' '
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+		}
+	}
+}
+
+func (p *selectorParser) step() (selectorStep, error) {
+	var step selectorStep
+	start := p.pos
+	if p.peek() == '*' {
+		p.pos++
+		step.tag = "*"
+	} else {
+		step.tag = strings.ToLower(p.ident())
+	}
+
+	for {
+		var condition func(*html.Node) bool
+		var err error
+		switch p.peek() {
+		case '#':
+			p.pos++
+			condition, err = p.named("id", '=')
+		case '.':
+			p.pos++
+			condition, err = p.named("class", '~')
+		case '[':
+			p.pos++
+			condition, err = p.attribute()
+		case ':':
+			p.pos++
+			condition, err = p.pseudoClass()
+		default:
+			if p.pos == start {
+				return step, fmt.Errorf("expected a selector at %d", p.pos)
+			}
+			return step, nil
+		}
+		if err != nil {
+			return step, err
+		}
+		step.conditions = append(step.conditions, condition)
+	}
+}
+
+// named parses the name after # or .
+func (p *selectorParser) named(attr string, op byte) (func(*html.Node) bool, error) {
+	name := p.ident()
+	if name == "" {
+		return nil, fmt.Errorf("expected a name at %d", p.pos)
+	}
+	return attrCondition(attr, op, name), nil
+}
+
+// attribute parses the rest of [name], [name=value] and the like
+func (p *selectorParser) attribute() (func(*html.Node) bool, error) {
+	p.skipSpace()
+	name := strings.ToLower(p.ident())
+	if name == "" {
+		return nil, fmt.Errorf("expected an attribute name at %d", p.pos)
+	}
+	p.skipSpace()
+	if p.peek() == ']' {
+		p.pos++
+		return attrCondition(name, 0, ""), nil
+	}
+
+	op := p.peek()
+	switch {
+	case op == '=':
+		p.pos++
+	case strings.IndexByte("~^$*", op) >= 0 && strings.HasPrefix(p.input[p.pos+1:], "="):
+		p.pos += 2
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", op, p.pos)
+	}
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.peek() != ']' {
+		return nil, fmt.Errorf("expected ']' at %d", p.pos)
+	}
+	p.pos++
+	return attrCondition(name, op, value), nil
+}
+
+// pseudoClass parses the rest of :contains("text")
+func (p *selectorParser) pseudoClass() (func(*html.Node) bool, error) {
+	name := p.ident()
+	if name != "contains" {
+		return nil, fmt.Errorf("unsupported pseudo-class :%s", name)
+	}
+	if p.peek() != '(' {
+		return nil, fmt.Errorf("expected '(' at %d", p.pos)
+	}
+	p.pos++
+	p.skipSpace()
+	text, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.peek() != ')' {
+		return nil, fmt.Errorf("expected ')' at %d", p.pos)
+	}
+	p.pos++
+
+	text = strings.ToLower(text)
+	return func(n *html.Node) bool {
+		return strings.Contains(strings.ToLower((&Element{node: n}).Text()), text)
+	}, nil
+}
+
+func (p *selectorParser) ident() string {
+	start := p.pos
+	for !p.done() {
+		c := p.peek()
+		if c != '-' && c != '_' && c < 0x80 && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// value parses a quoted string or a bare word
+func (p *selectorParser) value() (string, error) {
+	quote := p.peek()
+	if quote != '"' && quote != '\'' {
+		if value := p.ident(); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("expected a value at %d", p.pos)
+	}
+	end := strings.IndexByte(p.input[p.pos+1:], quote)
+	if end < 0 {
+		return "", fmt.Errorf("unclosed string at %d", p.pos)
+	}
+	value := p.input[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return value, nil
+}
+
+// attrCondition matches an attribute with a CSS operator, 0 only checks
+// that the attribute is there
+func attrCondition(name string, op byte, value string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		for _, attr := range n.Attr {
+			if attr.Key != name {
+				continue
+			}
+			switch op {
+			case 0:
+				return true
+			case '=':
+				return attr.Val == value
+			case '~':
+				return slices.Contains(strings.Fields(attr.Val), value)
+			case '^':
+				return value != "" && strings.HasPrefix(attr.Val, value)
+			case '$':
+				return value != "" && strings.HasSuffix(attr.Val, value)
+			case '*':
+				return value != "" && strings.Contains(attr.Val, value)
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package scrapers
+
+import (
+	"slices"
+	"testing"
+)
+
+const selectorTestPage = `<html><body>
+<div id="main" class="container anime-title-as">
+	<h1 class="title big">Naruto <b>Sub ITA</b></h1>
+	<ul class="eps">
+		<li><a class="bottone-ep" href="/ep/naruto-ep-1">Episodio 1</a></li>
+		<li><a class="bottone-ep" href="/ep/naruto-ep-2">Episodio 2</a></li>
+		<li><span><a href="https://cdn.example/naruto.m3u8">Stream</a></span></li>
+	</ul>
+</div>
+<a href="/watch?file=naruto-ep-1">Guarda lo Streaming</a>
+<video><source type="video/mp4" src="/v/1.mp4"></video>
+</body></html>`
+
+func TestCompileSelector(t *testing.T) {
+	doc, err := parseDocument("https://example.com/anime/naruto", selectorTestPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		selector string
+		want     []string // text of the matches, in document order
+	}{
+		{"h1", []string{"Naruto Sub ITA"}},
+		{"#main h1 b", []string{"Sub ITA"}},
+		{".title.big", []string{"Naruto Sub ITA"}},
+		{".title.small", nil},
+		{"ul > li > a", []string{"Episodio 1", "Episodio 2"}},
+		{"ul a", []string{"Episodio 1", "Episodio 2", "Stream"}},
+		{"ul > a", nil},
+		{"div > h1 > b", []string{"Sub ITA"}},
+		{"a[href*='naruto-ep']", []string{"Episodio 1", "Episodio 2", "Guarda lo Streaming"}},
+		{"a[href^='/ep/']", []string{"Episodio 1", "Episodio 2"}},
+		{"a[href$=\".m3u8\"]", []string{"Stream"}},
+		{"a[href^='']", nil},
+		{"[class~=container]", []string{"Naruto Sub ITA Episodio 1 Episodio 2 Stream"}},
+		{"div[class*='anime-title'] b", []string{"Sub ITA"}},
+		{"a[class=bottone-ep]", []string{"Episodio 1", "Episodio 2"}},
+		{"a:contains('guarda lo streaming')", []string{"Guarda lo Streaming"}},
+		{"a:contains(\"Episodio\")", []string{"Episodio 1", "Episodio 2"}},
+		{"li:contains('2') a", []string{"Episodio 2"}},
+		{"b, h1", []string{"Naruto Sub ITA", "Sub ITA"}},
+		{"*[href]", []string{"Episodio 1", "Episodio 2", "Stream", "Guarda lo Streaming"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, element := range doc.Find(tt.selector) {
+			got = append(got, element.Text())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Find(%q) = %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestCompileSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{
+		"",
+		"a,",
+		"a >",
+		".",
+		"#",
+		"a[",
+		"a[href",
+		"a[href=]",
+		"a[href='x'",
+		"a[href|='x']",
+		"a[href='x]",
+		"a:hover",
+		"a:contains",
+		"a:contains('x'",
+		"a:contains(x y)",
+		"a + b",
+	} {
+		if _, err := compileSelector(selector); err == nil {
+			t.Errorf("compileSelector(%q) succeeded, want an error", selector)
+		}
+	}
+}
+
+func TestValueAttribute(t *testing.T) {
+	doc, err := parseDocument("https://example.com/anime/naruto", selectorTestPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{"video source[type='video/mp4']@src", "/v/1.mp4"},
+		{"h1 b", "Sub ITA"},
+		{"a[href*='@']", ""},
+		{"video@missing", ""},
+	}
+	for _, tt := range tests {
+		if got := doc.Value(tt.selector); got != tt.want {
+			t.Errorf("Value(%q) = %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package scrapers
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"otakucrawler/commons"
+	"path/filepath"
+	"strings"
+)
+
+// SeriesInfo is the show metadata written next to downloads organized for a
+// media server
+type SeriesInfo struct {
+	Title     string
+	Year      int
+	Plot      string
+	Genres    []string
+	PosterURL string
+}
+
+type tvShowNFO struct {
+	XMLName xml.Name `xml:"tvshow"`
+	Title   string   `xml:"title"`
+	Year    int      `xml:"year,omitempty"`
+	Plot    string   `xml:"plot,omitempty"`
+	Genres  []string `xml:"genre"`
+	Thumb   string   `xml:"thumb,omitempty"`
+}
+
+type episodeNFO struct {
+	XMLName        xml.Name `xml:"episodedetails"`
+	Title          string   `xml:"title"`
+	ShowTitle      string   `xml:"showtitle"`
+	Season         int      `xml:"season"`
+	Episode        int      `xml:"episode"`                  // media centers only read whole numbers
+	DisplayEpisode string   `xml:"displayepisode,omitempty"` // half episodes, like 12.5
+}
+
+// writeSeriesMetadata writes tvshow.nfo and the poster into the series
+// folder. Existing files are left alone so manual edits survive.
+func writeSeriesMetadata(ctx context.Context, dir string, layout commons.Layout, info SeriesInfo) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create series directory: %w", err)
+	}
+
+	var errs []error
+	if layout.WritesNFO() {
+		nfo := tvShowNFO{
+			Title:  info.Title,
+			Year:   info.Year,
+			Plot:   info.Plot,
+			Genres: info.Genres,
+			Thumb:  info.PosterURL,
+		}
+		if err := writeNFO(filepath.Join(dir, "tvshow.nfo"), nfo); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if info.PosterURL != "" {
+		posterPath := filepath.Join(dir, "poster"+imageExtension(info.PosterURL))
+		if err := downloadPoster(ctx, info.PosterURL, posterPath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeEpisodeNFO writes the .nfo file next to an episode
+func writeEpisodeNFO(videoPath string, info SeriesInfo, name commons.EpisodeName) error {
+	title := name.Title
+	if title == "" {
+		title = fmt.Sprintf("Episode %s", commons.FormatEpisode(name.Episode, 1))
+	}
+	nfo := episodeNFO{
+		Title:     title,
+		ShowTitle: info.Title,
+		Season:    name.Season,
+		Episode:   int(name.Episode),
+	}
+	if name.Episode != float64(nfo.Episode) {
+		nfo.DisplayEpisode = commons.FormatEpisode(name.Episode, 1)
+	}
+	return writeNFO(strings.TrimSuffix(videoPath, filepath.Ext(videoPath))+".nfo", nfo)
+}
+
+func writeNFO(path string, nfo any) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	data, err := xml.MarshalIndent(nfo, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode %s: %w", filepath.Base(path), err)
+	}
+	data = append([]byte(xml.Header), data...)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func downloadPoster(ctx context.Context, posterURL, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	resp, err := httpGet(ctx, posterURL)
+	if err != nil {
+		return fmt.Errorf("could not download poster: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not download poster: %s", resp.Status)
+	}
+
+	file, err := os.Create(path + partSuffix)
+	if err != nil {
+		return fmt.Errorf("could not create poster: %w", err)
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + partSuffix)
+		return fmt.Errorf("could not save poster: %w", err)
+	}
+	return os.Rename(path+partSuffix, path)
+}
+
+// imageExtension returns the extension of an image URL, .jpg when unknown
+func imageExtension(imageURL string) string {
+	switch ext := urlExtension(imageURL); ext {
+	case "png", "webp", "jpeg", "jpg":
+		return "." + ext
+	default:
+		return ".jpg"
+	}
+}
@@ -7,11 +7,14 @@ import (
 )
 
 type TokenBucketRateLimitedReader struct {
+	ctx     context.Context
 	reader  io.Reader
 	limiter *rate.Limiter
 }
 
-func NewTokenBucketRateLimitedReader(reader io.Reader, maxBytesPerSecond int) *TokenBucketRateLimitedReader {
+// NewTokenBucketRateLimitedReader limits reads from reader to maxBytesPerSecond.
+// Waiting for tokens is aborted when ctx is cancelled.
+func NewTokenBucketRateLimitedReader(ctx context.Context, reader io.Reader, maxBytesPerSecond int) *TokenBucketRateLimitedReader {
 	burstSize := maxBytesPerSecond / 10 // 100ms of burst data
 	if burstSize > 16*1024 {            // Cap at 16KB for stable rates
 		burstSize = 16 * 1024
@@ -20,6 +23,7 @@ func NewTokenBucketRateLimitedReader(reader io.Reader, maxBytesPerSecond int) *T
 	limiter := rate.NewLimiter(rate.Limit(maxBytesPerSecond), burstSize)
 
 	return &TokenBucketRateLimitedReader{
+		ctx:     ctx,
 		reader:  reader,
 		limiter: limiter,
 	}
@@ -33,7 +37,7 @@ func (r *TokenBucketRateLimitedReader) Read(p []byte) (int, error) {
 
 	// Wait for tokens for the bytes we just read
 	if n > 0 {
-		err := r.limiter.WaitN(context.Background(), n)
+		err := r.limiter.WaitN(r.ctx, n)
 		if err != nil {
 			return n, err
 		}
@@ -1,15 +1,44 @@
 package scrapers
 
 import (
-	"github.com/playwright-community/playwright-go"
+	"context"
 	"net/url"
 	"otakucrawler/commons"
 	"strings"
 )
 
+// Scraper reads a site through a Fetcher, which loads pages over plain HTTP
+// when the site allows it and in the browser otherwise
 type Scraper interface {
-	GetLinks(page playwright.Page, browser playwright.Browser) []string
-	Download(page playwright.Page, browser playwright.Browser, episodeRange string, specificEpisodes string, config commons.DownloadConfig, ffmpegPath string)
+	FindSeries(ctx context.Context, f *Fetcher, site, query string) ([]SeriesResult, error)
+	Variants(ctx context.Context, f *Fetcher, seriesURL string) ([]SeriesResult, error)
+	Seasons(ctx context.Context, f *Fetcher, seriesURL string) ([]SeriesResult, error)
+	ListEpisodes(ctx context.Context, f *Fetcher, seriesURL string) ([]EpisodeLink, error)
+	GetLinks(ctx context.Context, f *Fetcher, seriesURL string, resolve bool) ([]EpisodeLink, error)
+	Download(ctx context.Context, f *Fetcher, seriesURL string, episodes *commons.EpisodeSelector, config commons.DownloadConfig, ffmpegPath string) error
+}
+
+// EpisodeLink is the streaming page found for an episode, or the reason it couldn't be found.
+// The stream fields are only filled when the links were resolved.
+type EpisodeLink struct {
+	Episode   string            `json:"episode"` // label as shown by the site, e.g. "12.5" or "OVA"
+	Special   bool              `json:"special,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	StreamURL string            `json:"stream_url,omitempty"`
+	HLS       bool              `json:"hls,omitempty"`
+	Filename  string            `json:"filename,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// SeriesResult is a series found by a title search
+type SeriesResult struct {
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Lang     string `json:"lang"` // commons.LangSubITA or commons.LangITA
+	Year     int    `json:"year,omitempty"`
+	Episodes int    `json:"episodes,omitempty"` // 0 when the site doesn't say
 }
 
 func GetScraper(link string) Scraper {
@@ -17,22 +46,10 @@ func GetScraper(link string) Scraper {
 	if err != nil {
 		return nil
 	}
-	host := strings.TrimPrefix(parsedURL.Hostname(), "www.")
+	host := strings.ToLower(strings.TrimPrefix(parsedURL.Hostname(), "www."))
 
-	switch {
-	case strings.Contains(host, "animesaturn"):
-		return &AnimeSaturnScraper{}
-	default:
-		return nil
+	if site := siteFor(host); site != nil {
+		return &GenericScraper{site: site}
 	}
-}
-
-type AnimeSaturnScraper struct{}
-
-func (s *AnimeSaturnScraper) GetLinks(page playwright.Page, browser playwright.Browser) []string {
-	return AnmstrnSearch(page, browser)
-}
-
-func (s *AnimeSaturnScraper) Download(page playwright.Page, browser playwright.Browser, episodeRange string, specificEpisodes string, config commons.DownloadConfig, ffmpegPath string) {
-	AnmstrnDownload(page, browser, episodeRange, specificEpisodes, config, ffmpegPath)
+	return nil
 }
@@ -0,0 +1,320 @@
+package scrapers
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"net/url"
+	"os"
+	"otakucrawler/commons"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// builtinSites holds the definitions of the supported sites. A file with the
+// same name in the sites folder of the config dir is read over the built-in
+// one, so a site change can be fixed without waiting for a release, and
+// other files there add sites.
+//
+//go:embed sites/*.yaml
+var builtinSites embed.FS
+
+// sites are the definitions read by LoadSites
+var sites []*SiteDefinition
+
+// SiteDefinition holds the selectors and patterns a site is read with.
+// Selectors ending in @name read that attribute instead of the text.
+type SiteDefinition struct {
+	Name     string        `yaml:"name"`
+	Hosts    []string      `yaml:"hosts"` // as in commons.SupportedDomains
+	Search   searchRules   `yaml:"search"`
+	Series   seriesRules   `yaml:"series"`
+	Episode  episodeRules  `yaml:"episode"`
+	Player   playerRules   `yaml:"player"`
+	Language languageRules `yaml:"language"`
+}
+
+type searchRules struct {
+	URL      string  `yaml:"url"` // with {site} and {query} placeholders
+	Item     string  `yaml:"item"`
+	Link     string  `yaml:"link"` // in the item, its text is the title
+	Episodes pattern `yaml:"episodes"`
+}
+
+type seriesRules struct {
+	Episodes       string   `yaml:"episodes"`
+	Title          []string `yaml:"title"`
+	Plot           []string `yaml:"plot"`
+	Poster         []string `yaml:"poster"`
+	ReleaseDate    string   `yaml:"release_date"`
+	Genres         string   `yaml:"genres"`
+	Related        []string `yaml:"related"`
+	RelatedHeading pattern  `yaml:"related_heading"`
+	RelatedLink    string   `yaml:"related_link"`
+}
+
+type episodeRules struct {
+	StreamingLink string `yaml:"streaming_link"`
+}
+
+type playerRules struct {
+	MP4 string    `yaml:"mp4"`
+	HLS []pattern `yaml:"hls"`
+}
+
+type languageRules struct {
+	SubITA pattern `yaml:"sub_ita"`
+	ITA    pattern `yaml:"ita"`
+}
+
+// pattern is a regular expression compiled when the definition is read, nil
+// when left empty
+type pattern struct {
+	*regexp.Regexp
+}
+
+func (p *pattern) UnmarshalYAML(value *yaml.Node) error {
+	var source string
+	if err := value.Decode(&source); err != nil {
+		return err
+	}
+	if source == "" {
+		p.Regexp = nil
+		return nil
+	}
+	re, err := regexp.Compile(source)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid pattern: %w", value.Line, err)
+	}
+	p.Regexp = re
+	return nil
+}
+
+// siteExtensions are the definition files read from the sites folder, JSON
+// is read as YAML
+var siteExtensions = []string{".yaml", ".yml", ".json"}
+
+// LoadSites reads the built-in site definitions, each with the file of the
+// same name in dir read over it, then the other files in dir as new sites.
+// Fields an override leaves out keep their built-in values. The hosts of
+// every site become the supported domains. It returns the files read from
+// dir, with an empty dir only the built-in definitions are read.
+func LoadSites(dir string) ([]string, error) {
+	var userFiles []string
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("could not read site definitions: %w", err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && slices.Contains(siteExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+				userFiles = append(userFiles, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	builtinFiles, err := fs.Glob(builtinSites, "sites/*.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("could not list site definitions: %w", err)
+	}
+
+	var loaded []*SiteDefinition
+	var used []string
+	for _, file := range builtinFiles {
+		data, err := builtinSites.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read site definition: %w", err)
+		}
+		site := &SiteDefinition{}
+		if err := decodeSite(file, data, site); err != nil {
+			return nil, err
+		}
+		for _, userFile := range userFiles {
+			if siteFileName(userFile) != siteFileName(file) {
+				continue
+			}
+			if err := readSiteFile(userFile, site); err != nil {
+				return nil, err
+			}
+			used = append(used, userFile)
+		}
+		if err := site.validate(); err != nil {
+			return nil, fmt.Errorf("site definition %s: %w", siteFileName(file), err)
+		}
+		loaded = append(loaded, site)
+	}
+
+	for _, userFile := range userFiles {
+		if slices.Contains(used, userFile) {
+			continue
+		}
+		site := &SiteDefinition{}
+		if err := readSiteFile(userFile, site); err != nil {
+			return nil, err
+		}
+		if err := site.validate(); err != nil {
+			return nil, fmt.Errorf("site definition %s: %w", userFile, err)
+		}
+		if slices.ContainsFunc(loaded, func(other *SiteDefinition) bool { return other.Name == site.Name }) {
+			return nil, fmt.Errorf("site definition %s: name %q is already used", userFile, site.Name)
+		}
+		loaded = append(loaded, site)
+		used = append(used, userFile)
+	}
+
+	sites = loaded
+	commons.SupportedDomains = nil
+	for _, site := range sites {
+		commons.SupportedDomains = append(commons.SupportedDomains, site.Hosts...)
+	}
+	return used, nil
+}
+
+func readSiteFile(file string, site *SiteDefinition) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read site definition: %w", err)
+	}
+	return decodeSite(file, data, site)
+}
+
+// decodeSite reads a definition over site, keeping the fields it leaves out
+func decodeSite(file string, data []byte, site *SiteDefinition) error {
+	if err := yaml.Unmarshal(data, site); err != nil {
+		return fmt.Errorf("site definition %s: %w", file, err)
+	}
+	return nil
+}
+
+// siteFileName is the name of a definition file without its extension
+func siteFileName(file string) string {
+	base := path.Base(filepath.ToSlash(file))
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// siteFor returns the definition of the site host belongs to
+func siteFor(host string) *SiteDefinition {
+	for _, site := range sites {
+		for _, domain := range site.Hosts {
+			if commons.MatchesDomain(host, domain) {
+				return site
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks what every scraper needs and that the selectors parse,
+// so a broken override fails at startup rather than on every page
+func (s *SiteDefinition) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(s.Hosts) == 0 {
+		return fmt.Errorf("hosts is required")
+	}
+	if s.Series.Episodes == "" {
+		return fmt.Errorf("series.episodes is required")
+	}
+	if len(s.Series.Title) == 0 {
+		return fmt.Errorf("series.title is required")
+	}
+
+	selectors := []string{s.Search.Item, s.Search.Link, s.Series.Episodes, s.Series.ReleaseDate, s.Series.Genres, s.Series.RelatedLink, s.Episode.StreamingLink, s.Player.MP4}
+	selectors = append(selectors, s.Series.Title...)
+	selectors = append(selectors, s.Series.Plot...)
+	selectors = append(selectors, s.Series.Poster...)
+	selectors = append(selectors, s.Series.Related...)
+	for _, selector := range selectors {
+		if selector == "" {
+			continue
+		}
+		selector, _ = splitAttrSelector(selector)
+		if _, err := compileSelector(selector); err != nil {
+			return fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+	}
+	return nil
+}
+
+// Pages are read once they have what the scraper looks for, pages without
+// it over plain HTTP are loaded in the browser
+func (s *SiteDefinition) seriesReady(doc *Document) bool {
+	return doc.Has(s.Series.Episodes)
+}
+
+func (s *SiteDefinition) searchReady(doc *Document) bool {
+	return s.Search.Item == "" || doc.Has(s.Search.Item)
+}
+
+func (s *SiteDefinition) episodeReady(doc *Document) bool {
+	return doc.Has(s.Episode.StreamingLink)
+}
+
+func (s *SiteDefinition) playerReady(doc *Document) bool {
+	if doc.HasStreams() {
+		return true
+	}
+	if s.Player.MP4 != "" && doc.Value(s.Player.MP4) != "" {
+		return true
+	}
+	_, err := s.hlsURL(doc.HTML())
+	return err == nil
+}
+
+// searchURL fills the search address of the site
+func (s *SiteDefinition) searchURL(site, query string) (string, error) {
+	if s.Search.URL == "" {
+		return "", fmt.Errorf("%s has no search", s.Name)
+	}
+	return strings.NewReplacer("{site}", strings.TrimSuffix(site, "/"), "{query}", url.QueryEscape(query)).Replace(s.Search.URL), nil
+}
+
+// firstValue returns the first value found with selectors, tried in order
+func firstValue(doc *Document, selectors []string) string {
+	for _, selector := range selectors {
+		if value := doc.Value(selector); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// hlsURL returns the playlist the first matching HLS pattern finds in
+// content, from its first group when it has one
+func (s *SiteDefinition) hlsURL(content string) (string, error) {
+	for _, p := range s.Player.HLS {
+		if p.Regexp == nil {
+			continue
+		}
+		match := p.FindStringSubmatch(content)
+		if match == nil {
+			continue
+		}
+		if len(match) >= 2 && match[1] != "" {
+			return match[1], nil
+		}
+		return match[0], nil
+	}
+	return "", fmt.Errorf("could not find HLS URL in page content")
+}
+
+// splitLanguage separates the language the site appends to series titles
+// from the title
+func (s *SiteDefinition) splitLanguage(title string) (string, string) {
+	subITA, ita := s.Language.SubITA.Regexp, s.Language.ITA.Regexp
+	switch {
+	case subITA != nil && subITA.MatchString(title):
+		return strings.TrimSpace(subITA.ReplaceAllString(title, "")), commons.LangSubITA
+	case ita != nil && ita.MatchString(title):
+		return strings.TrimSpace(ita.ReplaceAllString(title, "")), commons.LangITA
+	default:
+		// Default to SUB_ITA if we can't determine
+		return title, commons.LangSubITA
+	}
+}
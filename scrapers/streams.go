@@ -0,0 +1,105 @@
+package scrapers
+
+import (
+	"fmt"
+	"github.com/playwright-community/playwright-go"
+	"log/slog"
+	"mime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type streamKind string
+
+const (
+	streamHLS  streamKind = "hls"
+	streamDASH streamKind = "dash"
+	streamMP4  streamKind = "mp4"
+)
+
+// capturedStream is a media request the browser made while loading a page
+type capturedStream struct {
+	URL  string
+	Kind streamKind
+}
+
+// streamKindOf recognizes media by content type, or by extension when
+// servers send a generic one
+func streamKindOf(rawURL, contentType string) (streamKind, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch strings.ToLower(mediaType) {
+	case "application/vnd.apple.mpegurl", "application/x-mpegurl", "audio/mpegurl", "audio/x-mpegurl":
+		return streamHLS, true
+	case "application/dash+xml":
+		return streamDASH, true
+	case "video/mp4":
+		return streamMP4, true
+	}
+	switch urlExtension(rawURL) {
+	case "m3u8":
+		return streamHLS, true
+	case "mpd":
+		return streamDASH, true
+	case "mp4":
+		return streamMP4, true
+	}
+	return "", false
+}
+
+// streamCapture records the media responses of a page, including the ones
+// of players in iframes, which never show up in the page HTML
+type streamCapture struct {
+	mu      sync.Mutex
+	streams []capturedStream
+	found   chan struct{} // closed on the first capture
+}
+
+func newStreamCapture() *streamCapture {
+	return &streamCapture{found: make(chan struct{})}
+}
+
+func (c *streamCapture) onResponse(response playwright.Response) {
+	status := response.Status()
+	if status < 200 || status >= 300 {
+		return
+	}
+	kind, ok := streamKindOf(response.URL(), response.Headers()["content-type"])
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.ContainsFunc(c.streams, func(s capturedStream) bool { return s.URL == response.URL() }) {
+		return
+	}
+	slog.Debug("Captured stream request", "url", response.URL(), "kind", kind)
+	c.streams = append(c.streams, capturedStream{URL: response.URL(), Kind: kind})
+	if len(c.streams) == 1 {
+		close(c.found)
+	}
+}
+
+func (c *streamCapture) list() []capturedStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.streams)
+}
+
+// pickCapturedStream returns the first HLS playlist the page requested,
+// or else the first MP4. Playlists win because fragmented HLS streams also
+// request .mp4 segments.
+func pickCapturedStream(streams []capturedStream) (string, bool, error) {
+	for _, kind := range []streamKind{streamHLS, streamMP4} {
+		for _, stream := range streams {
+			if stream.Kind == kind {
+				return stream.URL, kind == streamHLS, nil
+			}
+		}
+	}
+	if len(streams) > 0 {
+		return "", false, fmt.Errorf("only a DASH stream was found, which is not supported: %s", streams[0].URL)
+	}
+	return "", false, fmt.Errorf("no stream requests were captured")
+}
@@ -0,0 +1,205 @@
+package scrapers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"otakucrawler/commons"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mediaTags are the MP4 tags written into downloaded episodes
+type mediaTags struct {
+	Title     string
+	Show      string
+	Year      int
+	Season    int
+	Episode   float64
+	Lang      string // commons.LangSubITA or commons.LangITA
+	SourceURL string
+	CoverPath string // image attached as cover art, empty for none
+}
+
+func newMediaTags(name commons.EpisodeName, show, sourceURL, coverPath string) *mediaTags {
+	title := name.Title
+	if title == "" {
+		title = fmt.Sprintf("%s - Episode %s", show, commons.FormatEpisode(name.Episode, 1))
+	}
+	return &mediaTags{
+		Title:     title,
+		Show:      show,
+		Year:      name.Year,
+		Season:    name.Season,
+		Episode:   name.Episode,
+		Lang:      name.Lang,
+		SourceURL: sourceURL,
+		CoverPath: coverPath,
+	}
+}
+
+// audioLanguage returns the ISO 639-2 code of the audio track. Subbed
+// episodes keep the original Japanese audio.
+func (t *mediaTags) audioLanguage() string {
+	if t.Lang == commons.LangITA {
+		return "ita"
+	}
+	return "jpn"
+}
+
+// ffmpegArgs returns the ffmpeg output options writing the tags, for a
+// command whose first input is the video and second input is the cover.
+// A nil *mediaTags only copies the streams.
+func (t *mediaTags) ffmpegArgs() []string {
+	if t == nil {
+		return []string{"-c", "copy"}
+	}
+
+	args := []string{"-map", "0:v?", "-map", "0:a?"}
+	if t.CoverPath != "" {
+		args = append(args, "-map", "1", "-disposition:v:1", "attached_pic")
+	}
+	args = append(args, "-c", "copy")
+
+	metadata := [][2]string{
+		{"title", t.Title},
+		{"show", t.Show},
+		{"episode_id", fmt.Sprintf("S%02dE%s", t.Season, commons.FormatEpisode(t.Episode, 2))},
+		{"season_number", strconv.Itoa(t.Season)},
+		{"episode_sort", strconv.Itoa(int(t.Episode))},
+		{"track", strconv.Itoa(int(t.Episode))},
+		{"comment", t.SourceURL},
+	}
+	if t.Year > 0 {
+		metadata = append(metadata, [2]string{"date", strconv.Itoa(t.Year)})
+	}
+	for _, tag := range metadata {
+		if tag[1] != "" {
+			args = append(args, "-metadata", tag[0]+"="+tag[1])
+		}
+	}
+	return append(args, "-metadata:s:a:0", "language="+t.audioLanguage())
+}
+
+// ffmpegInputs returns the input options for the video at input and the
+// cover art
+func (t *mediaTags) ffmpegInputs(input string) []string {
+	args := []string{"-i", input}
+	if t != nil && t.CoverPath != "" {
+		args = append(args, "-i", t.CoverPath)
+	}
+	return args
+}
+
+// ffmpegFormats are the muxers of the video extensions whose name differs
+// from the extension
+var ffmpegFormats = map[string]string{
+	"m4v": "mp4",
+	"mkv": "matroska",
+	"ts":  "mpegts",
+}
+
+// ffmpegFormat returns the muxer for the extension of path. Files being
+// written end in .part, so ffmpeg can't tell it from the name.
+func ffmpegFormat(path string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if format, ok := ffmpegFormats[ext]; ok {
+		return format
+	}
+	if ext == "" {
+		return "mp4"
+	}
+	return ext
+}
+
+// tagVideo writes tags into an already downloaded file by remuxing it in
+// format, the streams are copied as they are
+func tagVideo(ctx context.Context, ffmpegCmd, path, format string, tags *mediaTags) error {
+	partPath := path + ".tags" + partSuffix
+	args := tags.ffmpegInputs(path)
+	args = append(args, tags.ffmpegArgs()...)
+	args = append(args, "-f", format, "-y", partPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegCmd, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(partPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg failed: %w\n%s", err, stderr.String())
+	}
+	return os.Rename(partPath, path)
+}
+
+// tagDownloadedVideo tags a complete direct download while it is still the
+// .part file at partPath, so an interrupted pass leaves nothing that looks
+// done. Failing to tag is only a warning, the episode itself is fine.
+func tagDownloadedVideo(ctx context.Context, logger *slog.Logger, ffmpegPath, partPath, outputPath string, tags *mediaTags, task *commons.ProgressTask) error {
+	ffmpegCmd, err := findFFmpeg(ffmpegPath)
+	if err != nil {
+		logger.Warn("Skipping metadata tags", "error", err)
+		return nil
+	}
+
+	task.SetStatus("tagging")
+	if err := tagVideo(ctx, ffmpegCmd, partPath, ffmpegFormat(outputPath), tags); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Warn("could not write metadata tags", "path", outputPath, "error", err)
+	}
+	return nil
+}
+
+// downloadCover saves the series poster to a temporary file for use as
+// cover art. The caller removes it. Formats MP4 can't hold are skipped.
+func downloadCover(ctx context.Context, posterURL string) (string, error) {
+	ext := imageExtension(posterURL)
+	if posterURL == "" || ext == ".webp" {
+		return "", nil
+	}
+
+	resp, err := httpGet(ctx, posterURL)
+	if err != nil {
+		return "", fmt.Errorf("could not download cover: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not download cover: %s", resp.Status)
+	}
+
+	file, err := os.CreateTemp("", "cover_*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("could not create cover file: %w", err)
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("could not save cover: %w", err)
+	}
+	slog.Debug("Downloaded cover art", "url", posterURL)
+	return file.Name(), nil
+}
+
+// findFFmpeg returns the ffmpeg command to run, preferring ffmpegPath
+func findFFmpeg(ffmpegPath string) (string, error) {
+	if ffmpegPath != "" {
+		return ffmpegPath, nil
+	}
+	// Fallback to system ffmpeg
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return "", fmt.Errorf("ffmpeg not found. Please install ffmpeg or ensure it's in your PATH")
+	}
+	return "ffmpeg", nil
+}
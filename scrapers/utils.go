@@ -1,15 +1,24 @@
 package scrapers
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/playwright-community/playwright-go"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"otakucrawler/commons"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,105 +43,119 @@ func cleanFilename(filename string) string {
 	return cleaned
 }
 
-func extractHLSUrl(page playwright.Page) (string, error) {
-	// Get the page content
-	content, err := page.Content()
+// episodeFilename is the name an episode gets with the default template,
+// suggested to external downloaders by exports
+func episodeFilename(name commons.EpisodeName) string {
+	template, _ := commons.ParseFilenameTemplate(commons.DefaultTemplate)
+	return filepath.Base(template.Render(name))
+}
+
+// browserUserAgent returns the user agent of the browser behind page, so
+// external downloaders can present themselves the same way
+func browserUserAgent(page playwright.Page) string {
+	userAgent, err := page.Evaluate(`() => navigator.userAgent`)
 	if err != nil {
-		return "", fmt.Errorf("could not get page content: %w", err)
+		slog.Warn("could not get user agent", "error", err)
+		return ""
 	}
+	ua, _ := userAgent.(string)
+	return ua
+}
 
-	// Look for jwplayer setup with file parameter
-	re := regexp.MustCompile(`file:\s*["']([^"']*\.m3u8[^"']*)["']`)
-	matches := re.FindStringSubmatch(content)
-	if len(matches) >= 2 {
-		return matches[1], nil
+// streamHeaders returns the headers a CDN may check before serving a stream
+func streamHeaders(referer, userAgent string) map[string]string {
+	headers := map[string]string{}
+	if referer != "" {
+		headers["Referer"] = referer
 	}
-
-	// Alternative pattern for different setups
-	re2 := regexp.MustCompile(`["']([^"']*\.m3u8[^"']*)["']`)
-	matches2 := re2.FindAllStringSubmatch(content, -1)
-	for _, match := range matches2 {
-		if len(match) >= 2 && strings.Contains(match[1], ".m3u8") {
-			return match[1], nil
-		}
+	if userAgent != "" {
+		headers["User-Agent"] = userAgent
 	}
-
-	return "", fmt.Errorf("could not find HLS URL in page content")
+	return headers
 }
 
-func downloadVideo(videoURL string, maxSpeedMbps float64) error {
-	parsedURL, err := url.Parse(videoURL)
+// resolveURL resolves href against the page it was found on
+func resolveURL(pageURL, href string) string {
+	base, err := url.Parse(pageURL)
 	if err != nil {
-		return fmt.Errorf("invalid URL: %w", err)
+		return href
 	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return base.ResolveReference(ref).String()
+}
 
-	pathSegments := strings.Split(parsedURL.Path, "/")
-	if len(pathSegments) < 2 {
-		return fmt.Errorf("URL path too short to determine folder/filename")
+// urlExtension returns the lowercase extension of the file behind rawURL,
+// without the dot
+func urlExtension(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
 	}
+	return strings.ToLower(strings.TrimPrefix(path.Ext(parsedURL.Path), "."))
+}
 
-	filename := pathSegments[len(pathSegments)-1]
-	subfolder := pathSegments[len(pathSegments)-2]
+// videoExtensions are the containers direct downloads are saved as
+var videoExtensions = []string{"mp4", "m4v", "mkv", "webm", "mov", "avi", "flv", "ts"}
 
-	outputDir := filepath.Join("OtakuCrawler Downloads", subfolder)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("could not create output directory: %w", err)
+// videoExtension returns the extension of the file behind videoURL, mp4
+// when the URL doesn't have one of videoExtensions
+func videoExtension(videoURL string) string {
+	if ext := urlExtension(videoURL); slices.Contains(videoExtensions, ext) {
+		return ext
 	}
+	return "mp4"
+}
 
-	outputPath := filepath.Join(outputDir, filename)
+// partSuffix marks files that are still being written
+const partSuffix = ".part"
 
-	// Check if file already exists
-	if fileInfo, err := os.Stat(outputPath); err == nil {
-		// File exists, check its size
-		existingSize := fileInfo.Size()
+type downloadResult struct {
+	Path    string
+	Size    int64
+	Skipped bool // the file was already complete on disk
+}
 
-		// Make a HEAD request to get the expected file size
-		resp, err := http.Head(videoURL)
-		if err != nil {
-			// If we can't determine the size, just download again to be safe
-			fmt.Printf("⚠️ Could not check file size for %s, downloading again\n", filename)
-		} else {
-			defer func(Body io.ReadCloser) {
-				err := Body.Close()
-				if err != nil {
-					_ = fmt.Errorf("could not close response body: %w", err)
-				}
-			}(resp.Body)
-
-			expectedSize := resp.ContentLength
-			if expectedSize > 0 && existingSize >= expectedSize {
-				// File is complete, no need to download again
-				fmt.Printf("✅ File already exists with correct size: %s (%.2f MB)\n",
-					outputPath, float64(existingSize)/(1024*1024))
-				return nil
-			}
-		}
+// downloadVideo downloads a direct video to outputPath, with tags written
+// into it unless tags is nil
+func downloadVideo(ctx context.Context, logger *slog.Logger, videoURL, outputPath, ffmpegPath string, maxSpeedMbps float64, tags *mediaTags, task *commons.ProgressTask) (downloadResult, error) {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return downloadResult{}, fmt.Errorf("could not create output directory: %w", err)
+	}
+	filename := filepath.Base(outputPath)
 
-		// File exists but is incomplete/different, will be overwritten
-		fmt.Printf("⚠️ File exists but appears incomplete: %s, downloading again\n", filename)
+	// Files only get their final name once complete, so an existing one is
+	// done. Its size can't be compared with the server's, writing tags
+	// changes it.
+	if fileInfo, err := os.Stat(outputPath); err == nil {
+		logger.Info("File already exists", "path", outputPath, "size", fileInfo.Size())
+		return downloadResult{Path: outputPath, Size: fileInfo.Size(), Skipped: true}, nil
 	}
 
-	// Start downloading the file
-	if maxSpeedMbps > 0 {
-		fmt.Printf("⏬ Downloading %s (max speed: %.1f Mbps)...\n", filename, maxSpeedMbps)
-	} else {
-		fmt.Printf("⏬ Downloading %s (no speed limit)...\n", filename)
+	// Data goes to a .part file that only gets its final name once complete,
+	// so an interrupted download can't be mistaken for a finished one and
+	// the next run can resume it
+	partPath := outputPath + partSuffix
+	var offset int64
+	if fileInfo, err := os.Stat(partPath); err == nil {
+		offset = fileInfo.Size()
 	}
 
-	outFile, err := os.Create(outputPath)
+	logger.Info("Downloading", "file", filename, "max_speed_mbps", maxSpeedMbps, "resume_from", offset)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, videoURL, nil)
 	if err != nil {
-		return fmt.Errorf("could not create output file: %w", err)
+		return downloadResult{}, fmt.Errorf("could not create request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
-	defer func(outFile *os.File) {
-		err := outFile.Close()
-		if err != nil {
-			_ = fmt.Errorf("could not close output file: %w", err)
-		}
-	}(outFile)
 
-	resp, err := http.Get(videoURL)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("HTTP error: %w", err)
+		return downloadResult{}, fmt.Errorf("HTTP error: %w", err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -141,14 +164,49 @@ func downloadVideo(videoURL string, maxSpeedMbps float64) error {
 		}
 	}(resp.Body)
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	openFlags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		openFlags |= os.O_APPEND
+		task.Resume(offset)
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range (or there was nothing to resume)
+		if offset > 0 {
+			logger.Warn("Server does not support resuming, starting over", "file", filename)
+		}
+		openFlags |= os.O_TRUNC
+		offset = 0
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Nothing is left after the offset, the run before stopped between
+		// the last byte and the rename
+		if size, ok := contentRangeSize(resp.Header.Get("Content-Range")); ok && size == offset {
+			logger.Info("Partial file already complete", "path", partPath, "size", offset)
+			task.SetTotalBytes(offset)
+			task.Resume(offset)
+			if err := finishDownload(ctx, logger, partPath, outputPath, ffmpegPath, tags, task); err != nil {
+				return downloadResult{}, err
+			}
+			return downloadResult{Path: outputPath, Size: offset}, nil
+		}
+		logger.Warn("Partial file doesn't match the server's, starting over", "file", filename)
+		if err := os.Remove(partPath); err != nil {
+			return downloadResult{}, fmt.Errorf("could not remove partial file: %w", err)
+		}
+		return downloadVideo(ctx, logger, videoURL, outputPath, ffmpegPath, maxSpeedMbps, tags, task)
+	default:
+		return downloadResult{}, fmt.Errorf("bad status: %s", resp.Status)
 	}
 
-	// Add a simple progress indicator
-	contentLength := resp.ContentLength
-	if contentLength > 0 {
-		fmt.Printf("Total size: %.2f MB\n", float64(contentLength)/(1024*1024))
+	outFile, err := os.OpenFile(partPath, openFlags, 0644)
+	if err != nil {
+		return downloadResult{}, fmt.Errorf("could not create output file: %w", err)
+	}
+
+	// Size is unknown (-1) for chunked responses, the bar then only shows bytes
+	if resp.ContentLength > 0 {
+		task.SetTotalBytes(offset + resp.ContentLength)
+	} else {
+		task.SetStatus("downloading")
 	}
 
 	startTime := time.Now()
@@ -157,10 +215,9 @@ func downloadVideo(videoURL string, maxSpeedMbps float64) error {
 	// Choose between rate-limited and unlimited download
 	if maxSpeedMbps > 0 {
 		// Rate-limited download
-		maxBytesPerSecond := int(maxSpeedMbps * 1024 * 1024 / 8)         // mbps -> bytes/sec
-		fmt.Printf("Rate limiting to %d bytes/sec\n", maxBytesPerSecond) // Debug output
+		maxBytesPerSecond := int(maxSpeedMbps * 1024 * 1024 / 8) // mbps -> bytes/sec
 
-		rateLimitedReader := NewTokenBucketRateLimitedReader(resp.Body, maxBytesPerSecond)
+		rateLimitedReader := NewTokenBucketRateLimitedReader(ctx, resp.Body, maxBytesPerSecond)
 		defer func(rateLimitedReader *TokenBucketRateLimitedReader) {
 			err := rateLimitedReader.Close()
 			if err != nil {
@@ -168,109 +225,138 @@ func downloadVideo(videoURL string, maxSpeedMbps float64) error {
 			}
 		}(rateLimitedReader)
 
-		written, err = io.Copy(outFile, rateLimitedReader)
+		written, err = io.Copy(outFile, task.Reader(rateLimitedReader))
 	} else {
 		// Unlimited download - direct copy
-		written, err = io.Copy(outFile, resp.Body)
+		written, err = io.Copy(outFile, task.Reader(resp.Body))
 	}
 
+	// Make sure whatever we got is on disk, it's what the next run resumes from
+	syncErr := outFile.Sync()
+	closeErr := outFile.Close()
+
 	if err != nil {
-		return fmt.Errorf("could not write to file: %w", err)
+		if ctx.Err() != nil {
+			logger.Info("Download interrupted, partial file kept for resuming", "path", partPath)
+			return downloadResult{}, ctx.Err()
+		}
+		return downloadResult{}, fmt.Errorf("could not write to file: %w", err)
+	}
+	if syncErr != nil || closeErr != nil {
+		return downloadResult{}, fmt.Errorf("could not write to file: %w", errors.Join(syncErr, closeErr))
+	}
+
+	if err := finishDownload(ctx, logger, partPath, outputPath, ffmpegPath, tags, task); err != nil {
+		return downloadResult{}, err
 	}
 
 	elapsed := time.Since(startTime).Seconds()
 	speed := float64(written) / elapsed / 1024 / 1024 // MB/s
 
-	fmt.Printf("✅ Downloaded to: %s (%.2f MB at %.2f MB/s)\n",
-		outputPath, float64(written)/(1024*1024), speed)
-	return nil
+	logger.Info("Downloaded", "path", outputPath, "size", offset+written, "speed_mb_s", speed)
+	return downloadResult{Path: outputPath, Size: offset + written}, nil
 }
 
-func downloadHLSVideo(hlsUrl, animeName, languageType string, episodeNum int, ffmpegPath string, maxSpeedMbps float64) error {
-	// Check if ffmpeg is available
-	var ffmpegCmd string
-	if ffmpegPath != "" {
-		ffmpegCmd = ffmpegPath
-	} else {
-		// Fallback to system ffmpeg
-		if _, err := exec.LookPath("ffmpeg"); err != nil {
-			return fmt.Errorf("ffmpeg not found. Please install ffmpeg or ensure it's in your PATH")
+// finishDownload tags a complete .part file and gives it its final name
+func finishDownload(ctx context.Context, logger *slog.Logger, partPath, outputPath, ffmpegPath string, tags *mediaTags, task *commons.ProgressTask) error {
+	if tags != nil {
+		if err := tagDownloadedVideo(ctx, logger, ffmpegPath, partPath, outputPath, tags, task); err != nil {
+			return err
 		}
-		ffmpegCmd = "ffmpeg"
 	}
+	if err := os.Rename(partPath, outputPath); err != nil {
+		return fmt.Errorf("could not rename finished download: %w", err)
+	}
+	return nil
+}
 
-	// Create output directory
-	outputDir := filepath.Join("OtakuCrawler Downloads", animeName)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("could not create output directory: %w", err)
+// contentRangeSize reads the full size from a Content-Range header, as in
+// "bytes */1234" sent with a 416
+func contentRangeSize(header string) (int64, bool) {
+	_, size, ok := strings.Cut(header, "/")
+	if !ok {
+		return 0, false
 	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
 
-	// Generate filename in the requested format: AnimeName_Ep_XX_SUB_ITA/ITA
-	filename := fmt.Sprintf("%s_Ep_%02d_%s.mp4", strings.Join(strings.Fields(animeName), ""), episodeNum, languageType)
-	outputPath := filepath.Join(outputDir, filename)
+func downloadHLSVideo(ctx context.Context, logger *slog.Logger, hlsUrl, outputPath, ffmpegPath, quality string, maxSpeedMbps float64, tags *mediaTags, task *commons.ProgressTask) (downloadResult, error) {
+	// Check if ffmpeg is available
+	ffmpegCmd, err := findFFmpeg(ffmpegPath)
+	if err != nil {
+		return downloadResult{}, err
+	}
 
-	// Check if file already exists and is complete
-	if fileInfo, err := os.Stat(outputPath); err == nil && fileInfo.Size() > 1024*1024*10 { // At least 10MB
-		fmt.Printf("✅ File already exists: %s (%.2f MB)\n",
-			outputPath, float64(fileInfo.Size())/(1024*1024))
-		return nil
+	// Create output directory
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return downloadResult{}, fmt.Errorf("could not create output directory: %w", err)
 	}
+	filename := filepath.Base(outputPath)
 
-	// Display download info with speed limit
-	if maxSpeedMbps > 0 {
-		fmt.Printf("⏬ Downloading HLS stream %s (max speed: %.1f Mbps)...\n", filename, maxSpeedMbps)
-	} else {
-		fmt.Printf("⏬ Downloading HLS stream %s (no speed limit)...\n", filename)
+	// The remux writes a .part file that only gets its final name once
+	// complete, so an existing one is done
+	if fileInfo, err := os.Stat(outputPath); err == nil {
+		logger.Info("File already exists", "path", outputPath, "size", fileInfo.Size())
+		return downloadResult{Path: outputPath, Size: fileInfo.Size(), Skipped: true}, nil
 	}
 
+	logger.Info("Downloading HLS stream", "file", filename, "max_speed_mbps", maxSpeedMbps)
+
 	startTime := time.Now()
 
 	// Use custom rate-limited HLS downloader instead of direct ffmpeg
-	err := downloadHLSWithCustomRateLimit(hlsUrl, outputPath, ffmpegCmd, maxSpeedMbps)
+	err = downloadHLSWithCustomRateLimit(ctx, logger, hlsUrl, outputPath, ffmpegCmd, quality, maxSpeedMbps, tags, task)
 	if err != nil {
-		return fmt.Errorf("HLS download failed: %w", err)
+		if ctx.Err() != nil {
+			return downloadResult{}, ctx.Err()
+		}
+		return downloadResult{}, fmt.Errorf("HLS download failed: %w", err)
 	}
 
 	// Check if file was created successfully
 	fileInfo, err := os.Stat(outputPath)
 	if err != nil {
-		return fmt.Errorf("output file not found after download: %w", err)
+		return downloadResult{}, fmt.Errorf("output file not found after download: %w", err)
 	}
 
 	elapsed := time.Since(startTime).Seconds()
 	sizeMB := float64(fileInfo.Size()) / (1024 * 1024)
 	actualSpeedMbps := (sizeMB * 8) / elapsed // Calculate actual speed in Mbps
 
-	fmt.Printf("✅ Downloaded to: %s (%.2f MB in %.1f seconds, %.1f Mbps)\n",
-		outputPath, sizeMB, elapsed, actualSpeedMbps)
-	return nil
+	logger.Info("Downloaded", "path", outputPath, "size", fileInfo.Size(), "seconds", elapsed, "speed_mbps", actualSpeedMbps)
+	return downloadResult{Path: outputPath, Size: fileInfo.Size()}, nil
 }
 
-func downloadHLSWithCustomRateLimit(hlsUrl, outputPath, ffmpegCmd string, maxSpeedMbps float64) error {
+func downloadHLSWithCustomRateLimit(ctx context.Context, logger *slog.Logger, hlsUrl, outputPath, ffmpegCmd, quality string, maxSpeedMbps float64, tags *mediaTags, task *commons.ProgressTask) error {
 	// Create a temporary directory for segments
 	tempDir, err := os.MkdirTemp("", "hls_download_*")
 	if err != nil {
 		return fmt.Errorf("could not create temp directory: %w", err)
 	}
+	// Segments are useless once the episode is remuxed or abandoned, this
+	// also runs when the download is cancelled
 	defer os.RemoveAll(tempDir)
 
 	// Convert Mbps to bytes per second
 	maxBytesPerSecond := 0
 	if maxSpeedMbps > 0 {
 		maxBytesPerSecond = int(maxSpeedMbps * 1000000 / 8)
-		fmt.Printf("Using TokenBucket rate limiter: %d bytes/sec (%.1f Mbps)\n", maxBytesPerSecond, maxSpeedMbps)
 	}
 
 	// Download the master playlist first
-	fmt.Println("Downloading HLS master playlist...")
+	task.SetStatus("fetching playlist")
 	masterPlaylistPath := filepath.Join(tempDir, "master.m3u8")
-	masterPlaylist, err := downloadFileWithTokenBucket(hlsUrl, masterPlaylistPath, maxBytesPerSecond)
+	masterPlaylist, err := downloadFileWithTokenBucket(ctx, hlsUrl, masterPlaylistPath, maxBytesPerSecond)
 	if err != nil {
 		return fmt.Errorf("could not download master playlist: %w", err)
 	}
 
 	// Check if this is a master playlist or a direct media playlist
-	bestQualityUrl, isMaster, err := getBestQualityPlaylist(masterPlaylist, hlsUrl)
+	variantUrl, isMaster, err := getQualityPlaylist(logger, masterPlaylist, hlsUrl, quality)
 	if err != nil {
 		return fmt.Errorf("could not parse playlist: %w", err)
 	}
@@ -279,14 +365,13 @@ func downloadHLSWithCustomRateLimit(hlsUrl, outputPath, ffmpegCmd string, maxSpe
 	var mediaPlaylistUrl string
 
 	if isMaster {
-		// Download the best quality media playlist
-		fmt.Printf("Downloading media playlist for best quality: %s\n", bestQualityUrl)
+		// Download the media playlist of the selected quality
 		mediaPlaylistPath := filepath.Join(tempDir, "media.m3u8")
-		mediaPlaylist, err = downloadFileWithTokenBucket(bestQualityUrl, mediaPlaylistPath, maxBytesPerSecond)
+		mediaPlaylist, err = downloadFileWithTokenBucket(ctx, variantUrl, mediaPlaylistPath, maxBytesPerSecond)
 		if err != nil {
 			return fmt.Errorf("could not download media playlist: %w", err)
 		}
-		mediaPlaylistUrl = bestQualityUrl
+		mediaPlaylistUrl = variantUrl
 	} else {
 		// This is already a media playlist
 		mediaPlaylist = masterPlaylist
@@ -303,36 +388,53 @@ func downloadHLSWithCustomRateLimit(hlsUrl, outputPath, ffmpegCmd string, maxSpe
 		return fmt.Errorf("no segments found in media playlist")
 	}
 
-	fmt.Printf("Found %d segments to download\n", len(segmentUrls))
-
 	// Download all segments with your token bucket rate limiting
-	err = downloadSegmentsWithTokenBucket(segmentUrls, tempDir, maxBytesPerSecond)
+	err = downloadSegmentsWithTokenBucket(ctx, segmentUrls, tempDir, maxBytesPerSecond, task)
 	if err != nil {
 		return fmt.Errorf("could not download segments: %w", err)
 	}
 
 	// Create a local playlist file pointing to downloaded segments
 	localPlaylistPath := filepath.Join(tempDir, "local_playlist.m3u8")
-	err = createLocalPlaylist(mediaPlaylist, localPlaylistPath, tempDir)
+	err = createLocalPlaylist(logger, mediaPlaylist, localPlaylistPath, tempDir)
 	if err != nil {
 		return fmt.Errorf("could not create local playlist: %w", err)
 	}
 
-	// Now use ffmpeg to convert the local segments to final video (no network involved)
-	fmt.Println("Converting segments to final video...")
-	cmd := exec.Command(ffmpegCmd,
-		"-i", localPlaylistPath,
-		"-c", "copy",
-		"-bsf:a", "aac_adtstoasc",
-		"-y", outputPath)
+	// Now use ffmpeg to convert the local segments to final video (no network involved).
+	// The output only gets its final name once ffmpeg is done with it.
+	task.SetStatus("remuxing")
+	partPath := outputPath + partSuffix
+	args := tags.ffmpegInputs(localPlaylistPath)
+	args = append(args, tags.ffmpegArgs()...)
+	args = append(args, "-bsf:a", "aac_adtstoasc", "-f", "mp4", "-y", partPath)
+	cmd := exec.CommandContext(ctx, ffmpegCmd, args...)
+
+	// Capture stderr instead of printing it over the progress bars, it's
+	// only interesting when ffmpeg fails
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(partPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg failed: %w\n%s", err, stderr.String())
+	}
+	return os.Rename(partPath, outputPath)
+}
 
-	// Capture stderr for debugging if needed
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// httpGet is http.Get bound to ctx, so cancelling stops the transfer
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
 }
 
-func downloadFileWithTokenBucket(url, outputPath string, maxBytesPerSecond int) (string, error) {
-	resp, err := http.Get(url)
+func downloadFileWithTokenBucket(ctx context.Context, url, outputPath string, maxBytesPerSecond int) (string, error) {
+	resp, err := httpGet(ctx, url)
 	if err != nil {
 		return "", err
 	}
@@ -347,7 +449,7 @@ func downloadFileWithTokenBucket(url, outputPath string, maxBytesPerSecond int)
 	// Use your existing TokenBucketRateLimitedReader
 	var reader io.Reader = resp.Body
 	if maxBytesPerSecond > 0 {
-		rateLimitedReader := NewTokenBucketRateLimitedReader(resp.Body, maxBytesPerSecond)
+		rateLimitedReader := NewTokenBucketRateLimitedReader(ctx, resp.Body, maxBytesPerSecond)
 		defer rateLimitedReader.Close()
 		reader = rateLimitedReader
 	}
@@ -361,13 +463,23 @@ func downloadFileWithTokenBucket(url, outputPath string, maxBytesPerSecond int)
 	return string(content), err
 }
 
-func getBestQualityPlaylist(playlist, baseUrl string) (string, bool, error) {
+type playlistVariant struct {
+	URL       string
+	Bandwidth int
+	Height    int
+}
+
+var resolutionRegex = regexp.MustCompile(`RESOLUTION=\d+x(\d+)`)
+
+// getQualityPlaylist picks the variant matching quality from a master
+// playlist: "best", "worst" or the best one no taller than a height.
+// The returned bool is false when playlist is already a media playlist.
+func getQualityPlaylist(logger *slog.Logger, playlist, baseUrl, quality string) (string, bool, error) {
 	lines := strings.Split(playlist, "\n")
 
 	// Check if this is a master playlist by looking for #EXT-X-STREAM-INF
 	isMaster := false
-	var bestBandwidth int
-	var bestUrl string
+	var variants []playlistVariant
 
 	// Extract base URL for relative paths
 	baseUrlParts := strings.Split(baseUrl, "/")
@@ -379,55 +491,74 @@ func getBestQualityPlaylist(playlist, baseUrl string) (string, bool, error) {
 
 		if strings.HasPrefix(line, "#EXT-X-STREAM-INF") {
 			isMaster = true
+			variant := playlistVariant{}
 			// Extract bandwidth
-			bandwidth := 0
 			if strings.Contains(line, "BANDWIDTH=") {
 				parts := strings.Split(line, "BANDWIDTH=")
 				if len(parts) > 1 {
 					bandwidthStr := strings.Split(parts[1], ",")[0]
-					fmt.Sscanf(bandwidthStr, "%d", &bandwidth)
+					fmt.Sscanf(bandwidthStr, "%d", &variant.Bandwidth)
 				}
 			}
+			if match := resolutionRegex.FindStringSubmatch(line); match != nil {
+				variant.Height, _ = strconv.Atoi(match[1])
+			}
 
 			// Get the URL from the next line
 			if i+1 < len(lines) {
 				nextLine := strings.TrimSpace(lines[i+1])
 				if nextLine != "" && !strings.HasPrefix(nextLine, "#") {
-					url := nextLine
-					if !strings.HasPrefix(url, "http") {
-						url = baseUrlPrefix + "/" + url
-					}
-
-					// Select the highest bandwidth (best quality)
-					if bandwidth > bestBandwidth {
-						bestBandwidth = bandwidth
-						bestUrl = url
+					variant.URL = nextLine
+					if !strings.HasPrefix(variant.URL, "http") {
+						variant.URL = baseUrlPrefix + "/" + variant.URL
 					}
+					variants = append(variants, variant)
 				}
 			}
 		}
 	}
 
-	if isMaster {
-		if bestUrl == "" {
-			return "", true, fmt.Errorf("no valid stream found in master playlist")
+	if !isMaster {
+		// Not a master playlist, return the original URL
+		return baseUrl, false, nil
+	}
+	if len(variants) == 0 {
+		return "", true, fmt.Errorf("no valid stream found in master playlist")
+	}
+
+	// Lowest bandwidth first
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Bandwidth < variants[j].Bandwidth
+	})
+
+	selected := variants[len(variants)-1]
+	switch quality {
+	case "", "best":
+	case "worst":
+		selected = variants[0]
+	default:
+		maxHeight, _ := strconv.Atoi(quality)
+		// Fall back to the smallest stream when none is short enough
+		selected = variants[0]
+		for _, variant := range variants {
+			if variant.Height > 0 && variant.Height <= maxHeight {
+				selected = variant
+			}
 		}
-		fmt.Printf("Selected best quality stream with bandwidth: %d\n", bestBandwidth)
-		return bestUrl, true, nil
 	}
 
-	// Not a master playlist, return the original URL
-	return baseUrl, false, nil
+	logger.Debug("Selected stream", "quality", quality, "bandwidth", selected.Bandwidth, "height", selected.Height)
+	return selected.URL, true, nil
 }
 
-func downloadSegmentsWithTokenBucket(urls []string, tempDir string, maxBytesPerSecond int) error {
-	if maxBytesPerSecond > 0 {
-		fmt.Printf("Downloading %d segments with TokenBucket rate limit %d bytes/sec...\n", len(urls), maxBytesPerSecond)
-	} else {
-		fmt.Printf("Downloading %d segments with no rate limit...\n", len(urls))
-	}
+func downloadSegmentsWithTokenBucket(ctx context.Context, urls []string, tempDir string, maxBytesPerSecond int, task *commons.ProgressTask) error {
+	task.SetTotalSegments(len(urls))
 
 	for i, segmentUrl := range urls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// Extract the original filename from the URL
 		urlParts := strings.Split(segmentUrl, "/")
 		originalFilename := urlParts[len(urlParts)-1]
@@ -440,7 +571,7 @@ func downloadSegmentsWithTokenBucket(urls []string, tempDir string, maxBytesPerS
 		// Use the original filename instead of generic segment_XXXX.ts
 		segmentPath := filepath.Join(tempDir, originalFilename)
 
-		resp, err := http.Get(segmentUrl)
+		resp, err := httpGet(ctx, segmentUrl)
 		if err != nil {
 			return fmt.Errorf("could not download segment %d (%s): %w", i, segmentUrl, err)
 		}
@@ -455,11 +586,11 @@ func downloadSegmentsWithTokenBucket(urls []string, tempDir string, maxBytesPerS
 		var reader io.Reader = resp.Body
 		var rateLimitedReader *TokenBucketRateLimitedReader
 		if maxBytesPerSecond > 0 {
-			rateLimitedReader = NewTokenBucketRateLimitedReader(resp.Body, maxBytesPerSecond)
+			rateLimitedReader = NewTokenBucketRateLimitedReader(ctx, resp.Body, maxBytesPerSecond)
 			reader = rateLimitedReader
 		}
 
-		_, err = io.Copy(file, reader)
+		_, err = io.Copy(file, task.Reader(reader))
 
 		// Clean up
 		if rateLimitedReader != nil {
@@ -472,13 +603,9 @@ func downloadSegmentsWithTokenBucket(urls []string, tempDir string, maxBytesPerS
 			return fmt.Errorf("could not write segment %d: %w", i, err)
 		}
 
-		// Progress indicator
-		if (i+1)%10 == 0 || i == len(urls)-1 {
-			fmt.Printf("Downloaded %d/%d segments\n", i+1, len(urls))
-		}
+		task.SegmentDone()
 	}
 
-	fmt.Println("All segments downloaded successfully")
 	return nil
 }
 
@@ -508,11 +635,10 @@ func parsePlaylist(playlist, baseUrl string) ([]string, error) {
 	return urls, nil
 }
 
-func createLocalPlaylist(originalPlaylist, localPlaylistPath, segmentDir string) error {
+func createLocalPlaylist(logger *slog.Logger, originalPlaylist, localPlaylistPath, segmentDir string) error {
 	lines := strings.Split(originalPlaylist, "\n")
 	var newLines []string
 
-	fmt.Println("Creating local playlist...")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if line != "" && !strings.HasPrefix(line, "#") {
@@ -528,7 +654,7 @@ func createLocalPlaylist(originalPlaylist, localPlaylistPath, segmentDir string)
 			// Verify the file exists
 			fullPath := filepath.Join(segmentDir, filename)
 			if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-				fmt.Printf("WARNING: Local file does not exist: %s\n", fullPath)
+				logger.Warn("Local segment file does not exist", "path", fullPath)
 			}
 
 			// Use the original filename instead of generic segment names
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"otakucrawler/commons"
+	"otakucrawler/scrapers"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobCompleted jobStatus = "completed"
+	jobFailed    jobStatus = "failed"
+)
+
+// job is a download requested through the HTTP API
+type job struct {
+	ID       int        `json:"id"`
+	URL      string     `json:"url"`
+	Episodes string     `json:"episodes,omitempty"`
+	Status   jobStatus  `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	selector *commons.EpisodeSelector
+}
+
+// jobQueue runs jobs one at a time. A job already downloads a batch of
+// episodes at once within the speed limit, and owns the console progress
+// while it runs, so jobs side by side would go over both.
+type jobQueue struct {
+	mu      sync.Mutex
+	jobs    []*job
+	pending chan *job
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{pending: make(chan *job, 100)}
+}
+
+func (q *jobQueue) add(request job, selector *commons.EpisodeSelector) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j := &job{
+		ID:       len(q.jobs) + 1,
+		URL:      request.URL,
+		Episodes: selector.String(),
+		Status:   jobQueued,
+		Created:  time.Now(),
+		selector: selector,
+	}
+	select {
+	case q.pending <- j:
+	default:
+		return job{}, errors.New("too many queued jobs")
+	}
+	q.jobs = append(q.jobs, j)
+	return *j, nil
+}
+
+func (q *jobQueue) get(id int) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if id < 1 || id > len(q.jobs) {
+		return job{}, false
+	}
+	return *q.jobs[id-1], true
+}
+
+func (q *jobQueue) list() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, *j)
+	}
+	return jobs
+}
+
+func (q *jobQueue) update(j *job, fn func(*job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(j)
+}
+
+// work runs queued jobs until ctx is cancelled
+func (q *jobQueue) work(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult) {
+	for {
+		var j *job
+		select {
+		case <-ctx.Done():
+			return
+		case j = <-q.pending:
+		}
+
+		q.update(j, func(j *job) {
+			now := time.Now()
+			j.Status = jobRunning
+			j.Started = &now
+		})
+		slog.Info("Starting job", "id", j.ID, "url", j.URL)
+
+		err := downloadSeries(ctx, fetcher, setupResult, j.URL, j.selector)
+
+		q.update(j, func(j *job) {
+			now := time.Now()
+			j.Finished = &now
+			j.Status = jobCompleted
+			if err != nil {
+				j.Status = jobFailed
+				j.Error = err.Error()
+			}
+		})
+		if err != nil {
+			slog.Error("Job failed", "id", j.ID, "url", j.URL, "error", err)
+		} else {
+			slog.Info("Job completed", "id", j.ID, "url", j.URL)
+		}
+	}
+}
+
+// serve runs the HTTP API until ctx is cancelled
+func serve(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult) error {
+	queue := newJobQueue()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		// Unknown fields are refused, a misspelled episodes would download
+		// the whole series
+		var request job
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if request.URL == "" {
+			writeError(w, http.StatusBadRequest, errors.New("url is required"))
+			return
+		}
+		if scrapers.GetScraper(request.URL) == nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("scraper not available for %s", request.URL))
+			return
+		}
+		selector, err := commons.ParseEpisodeSelector(request.Episodes)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		created, err := queue.add(request, selector)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		slog.Info("Queued job", "id", created.ID, "url", created.URL)
+		writeJSON(w, http.StatusAccepted, created)
+	})
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, queue.list())
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid job id"))
+			return
+		}
+		j, ok := queue.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("job not found"))
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	})
+
+	server := &http.Server{Addr: setupResult.Listen, Handler: mux}
+
+	// Also stops the worker when the server can't start
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		queue.work(ctx, fetcher, setupResult)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Listening", "address", setupResult.Listen)
+	err := server.ListenAndServe()
+	cancel()
+	wg.Wait()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Server failed", "error", err)
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Debug("could not write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
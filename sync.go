@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"otakucrawler/commons"
+	"otakucrawler/scrapers"
+	"path/filepath"
+	"strings"
+)
+
+// syncWatchlist downloads the new episodes of every series in the watchlist,
+// the ones not on disk yet.
+// A --link is added to the watchlist first.
+func syncWatchlist(ctx context.Context, fetcher *scrapers.Fetcher, setupResult commons.SetupResult) error {
+	if setupResult.URL != "" {
+		if err := addToWatchlist(setupResult.Watchlist, setupResult.URL); err != nil {
+			slog.Error("Could not update watchlist", "error", err)
+			return err
+		}
+	}
+
+	urls, err := readWatchlist(setupResult.Watchlist)
+	if err != nil {
+		slog.Error("Could not read watchlist", "error", err)
+		return err
+	}
+	if len(urls) == 0 {
+		slog.Warn("Watchlist is empty, add series with 'otakucrawler sync --link URL'", "path", setupResult.Watchlist)
+		return nil
+	}
+
+	newEpisodes, err := commons.ParseEpisodeSelector("new")
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Syncing watchlist", "path", setupResult.Watchlist, "series", len(urls))
+	failed := 0
+	for _, url := range urls {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Info("Syncing series", "url", url)
+		if err := downloadSeries(ctx, fetcher, setupResult, url, newEpisodes); err != nil {
+			failed++
+			slog.Error("Sync failed", "url", url, "error", err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d series failed", failed, len(urls))
+	}
+	return nil
+}
+
+// readWatchlist returns the series URLs in path, one per line. Blank lines
+// and lines starting with # are ignored.
+func readWatchlist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open watchlist: %w", err)
+	}
+	defer file.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read watchlist: %w", err)
+	}
+	return urls, nil
+}
+
+func addToWatchlist(path, url string) error {
+	urls, err := readWatchlist(path)
+	if err != nil {
+		return err
+	}
+	for _, existing := range urls {
+		if existing == url {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create watchlist directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open watchlist: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, url); err != nil {
+		return fmt.Errorf("could not write watchlist: %w", err)
+	}
+	slog.Info("Added to watchlist", "url", url, "path", path)
+	return nil
+}